@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ModelAliases maps friendly model names to full model IDs, e.g.
+// gpt4 -> openai/gpt-4o-2024-08-06. It can be replaced while in use.
+type ModelAliases struct {
+	mu      sync.RWMutex
+	names   []string
+	targets map[string]string
+}
+
+var modelAliases = &ModelAliases{targets: make(map[string]string)}
+
+// modelAliasesPath is the aliases file, which aliases created through the API
+// are saved to.
+var modelAliasesPath = "aliases"
+
+// Resolve returns the full model ID of an alias.
+func (a *ModelAliases) Resolve(name string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	target, ok := a.targets[name]
+	return target, ok
+}
+
+// Each calls fn for every alias in the order of the aliases file.
+func (a *ModelAliases) Each(fn func(name string, target string)) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, name := range a.names {
+		fn(name, a.targets[name])
+	}
+}
+
+func (a *ModelAliases) Len() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.names)
+}
+
+// Set adds an alias or points an existing one to a new target.
+func (a *ModelAliases) Set(name string, target string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.targets[name]; !ok {
+		a.names = append(a.names, name)
+	}
+	a.targets[name] = target
+}
+
+// Remove deletes an alias, reporting whether it existed.
+func (a *ModelAliases) Remove(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.targets[name]; !ok {
+		return false
+	}
+	delete(a.targets, name)
+	a.names = slices.DeleteFunc(a.names, func(n string) bool { return n == name })
+	return true
+}
+
+func (a *ModelAliases) replace(other *ModelAliases) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.names = other.names
+	a.targets = other.targets
+}
+
+// loadModelAliases reads an aliases file with one "alias=full/model-id" entry
+// per line. Blank lines are skipped.
+func loadModelAliases(path string) (*ModelAliases, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	aliases := &ModelAliases{targets: make(map[string]string)}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		name, target, ok := strings.Cut(line, "=")
+		name, target = strings.TrimSpace(name), strings.TrimSpace(target)
+		if !ok || name == "" || target == "" {
+			return nil, fmt.Errorf("%s:%d: expected alias=model", path, lineNumber)
+		}
+		if _, ok := aliases.targets[name]; !ok {
+			aliases.names = append(aliases.names, name)
+		}
+		aliases.targets[name] = target
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
+
+// save writes the aliases to path in the format read by loadModelAliases. The
+// file is replaced atomically, so a concurrent reload never sees it partly
+// written.
+func (a *ModelAliases) save(path string) error {
+	a.mu.RLock()
+	var b strings.Builder
+	for _, name := range a.names {
+		fmt.Fprintf(&b, "%s=%s\n", name, a.targets[name])
+	}
+	a.mu.RUnlock()
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// withAliases appends an entry named after each alias to the listed models
+// whose target is among them.
+func withAliases(models []Model) []Model {
+	byID := make(map[string]Model, len(models))
+	for _, m := range models {
+		byID[m.ID] = m
+	}
+	modelAliases.Each(func(name string, target string) {
+		if m, ok := byID[target]; ok {
+			m.Name = name
+			m.Model = name
+			models = append(models, m)
+		}
+	})
+	return models
+}
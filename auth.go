@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contextKeyAPIKey is the gin context key of the proxy API key a request was
+// authenticated with.
+const contextKeyAPIKey = "api_key"
+
+// unauthenticatedPaths stay reachable without a key, so that health probes
+// and Ollama detection keep working.
+var unauthenticatedPaths = map[string]struct{}{
+	"/":        {},
+	"/healthz": {},
+	"/livez":   {},
+}
+
+// parseProxyAPIKeys splits the comma-separated PROXY_API_KEY value.
+func parseProxyAPIKeys(value string) []string {
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// parseClientKeys reads the PROXY_KEY_MAP JSON object mapping proxy API keys
+// to the upstream API keys their requests are sent with.
+func parseClientKeys(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var keys map[string]string
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// requireAPIKey rejects requests without an "Authorization: Bearer <key>"
+// header carrying one of the given keys. The key is stored in the context
+// under contextKeyAPIKey.
+func requireAPIKey(keys []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := unauthenticatedPaths[c.Request.URL.Path]; ok {
+			c.Next()
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		token = strings.TrimSpace(token)
+		if !ok || !validAPIKey(keys, token) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or missing API key"})
+			return
+		}
+		c.Set(contextKeyAPIKey, token)
+		c.Next()
+	}
+}
+
+func validAPIKey(keys []string, token string) bool {
+	valid := false
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// apiKeyUser identifies the caller of a request to the upstream's abuse
+// monitoring without revealing their key, or returns "" without auth.
+func apiKeyUser(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
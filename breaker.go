@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var errCircuitOpen = errors.New("upstream unavailable, failing fast after repeated errors")
+
+// circuitState is the state of a circuitBreaker as reported by /healthz.
+type circuitState string
+
+const (
+	circuitClosed   circuitState = "closed"
+	circuitOpen     circuitState = "open"
+	circuitHalfOpen circuitState = "half-open"
+)
+
+// circuitBreaker fails upstream requests fast once threshold requests in a row
+// failed with a network error, timeout or 5xx status, rather than making every
+// request wait for the failing upstream. After cooldown, a single request is
+// let through to probe whether the upstream recovered. It wraps the retry
+// transport, so a request counts once however often it was retried.
+type circuitBreaker struct {
+	base      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// newCircuitBreaker reads CIRCUIT_BREAKER_THRESHOLD (0, the default, disables
+// the breaker) and CIRCUIT_BREAKER_COOLDOWN.
+func newCircuitBreaker(base http.RoundTripper) *circuitBreaker {
+	return &circuitBreaker{
+		base:      base,
+		threshold: getEnvInt("CIRCUIT_BREAKER_THRESHOLD", 0),
+		cooldown:  getEnvDuration("CIRCUIT_BREAKER_COOLDOWN", 30*time.Second),
+	}
+}
+
+func (b *circuitBreaker) State() circuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state()
+}
+
+// state must be called with mu held.
+func (b *circuitBreaker) state() circuitState {
+	switch {
+	case b.threshold <= 0 || b.failures < b.threshold:
+		return circuitClosed
+	case time.Since(b.openedAt) < b.cooldown:
+		return circuitOpen
+	default:
+		return circuitHalfOpen
+	}
+}
+
+func (b *circuitBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if b.threshold <= 0 {
+		return b.base.RoundTrip(req)
+	}
+
+	// whether this request is the one probing the half-open circuit
+	var probe bool
+	b.mu.Lock()
+	switch b.state() {
+	case circuitOpen:
+		b.mu.Unlock()
+		return nil, errCircuitOpen
+	case circuitHalfOpen:
+		if b.probing {
+			b.mu.Unlock()
+			return nil, errCircuitOpen
+		}
+		b.probing = true
+		probe = true
+	}
+	b.mu.Unlock()
+
+	resp, err := b.base.RoundTrip(req)
+	failed := (err != nil && !errors.Is(req.Context().Err(), context.Canceled)) || (err == nil && resp.StatusCode >= http.StatusInternalServerError)
+	canceled := err != nil && !failed
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	} else if b.failures >= b.threshold {
+		// admitted before the circuit opened: only the probe decides
+		// whether it closes again
+		return resp, err
+	}
+	switch {
+	case canceled:
+		// the client went away, which says nothing about the upstream
+	case failed:
+		b.failures++
+		if b.failures >= b.threshold {
+			if b.failures == b.threshold || probe {
+				slog.WarnContext(req.Context(), "Upstream failing, opening circuit breaker", "failures", b.failures, "cooldown", b.cooldown)
+			}
+			b.openedAt = time.Now()
+		}
+	default:
+		if b.failures >= b.threshold {
+			slog.InfoContext(req.Context(), "Upstream recovered, closing circuit breaker")
+		}
+		b.failures = 0
+	}
+	return resp, err
+}
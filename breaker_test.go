@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// A request admitted while the circuit was closed doesn't end the probe of
+// the half-open circuit, or close it, when it completes late.
+func TestCircuitBreakerLateRequestDuringHalfOpen(t *testing.T) {
+	release := map[string]chan int{"slow": make(chan int), "probe": make(chan int)}
+	breaker := &circuitBreaker{
+		threshold: 1,
+		cooldown:  10 * time.Millisecond,
+		base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			status := http.StatusBadGateway
+			if wait, ok := release[req.URL.Path[1:]]; ok {
+				status = <-wait
+			}
+			recorder := httptest.NewRecorder()
+			recorder.WriteHeader(status)
+			return recorder.Result(), nil
+		}),
+	}
+	roundTrip := func(path string) (*http.Response, error) {
+		return breaker.RoundTrip(httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	done := func(path string) chan error {
+		errs := make(chan error, 1)
+		go func() {
+			_, err := roundTrip(path)
+			errs <- err
+		}()
+		return errs
+	}
+
+	slow := done("/slow")
+	time.Sleep(10 * time.Millisecond) // admitted while closed
+	if _, err := roundTrip("/fail"); err != nil {
+		t.Fatal(err)
+	}
+	if state := breaker.State(); state != circuitOpen {
+		t.Fatalf("state after a failure = %s, want open", state)
+	}
+	time.Sleep(20 * time.Millisecond)
+	probe := done("/probe")
+	time.Sleep(10 * time.Millisecond) // admitted as the probe
+
+	release["slow"] <- http.StatusOK
+	if err := <-slow; err != nil {
+		t.Fatal(err)
+	}
+	if state := breaker.State(); state != circuitHalfOpen {
+		t.Errorf("state after the late success = %s, want half-open", state)
+	}
+	if _, err := roundTrip("/other"); err != errCircuitOpen {
+		t.Errorf("request during the probe: err = %v, want the circuit open", err)
+	}
+
+	release["probe"] <- http.StatusOK
+	if err := <-probe; err != nil {
+		t.Fatal(err)
+	}
+	if state := breaker.State(); state != circuitClosed {
+		t.Errorf("state after the probe succeeded = %s, want closed", state)
+	}
+}
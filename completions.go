@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// UpstreamAPI selects the backend endpoint a chat request is sent to.
+type UpstreamAPI string
+
+const (
+	// UpstreamAPIChat uses the chat completions endpoint (the default).
+	UpstreamAPIChat UpstreamAPI = "chat"
+	// UpstreamAPICompletions uses the legacy completions endpoint with the
+	// messages rendered into a single prompt.
+	UpstreamAPICompletions UpstreamAPI = "completions"
+)
+
+func parseUpstreamAPI(value string) (UpstreamAPI, error) {
+	switch api := UpstreamAPI(value); api {
+	case "":
+		return UpstreamAPIChat, nil
+	case UpstreamAPIChat, UpstreamAPICompletions:
+		return api, nil
+	default:
+		return "", fmt.Errorf("unknown api %q, expected chat or completions", value)
+	}
+}
+
+// completionPrompt renders chat messages into a prompt for the completions
+// endpoint. A single message is sent as is, a conversation as a transcript
+// ending with the assistant's turn.
+func completionPrompt(messages []openai.ChatCompletionMessage) string {
+	if len(messages) == 1 {
+		return messageText(messages[0])
+	}
+
+	var sb strings.Builder
+	for _, message := range messages {
+		role := message.Role
+		if role != "" {
+			role = strings.ToUpper(role[:1]) + role[1:]
+		}
+		fmt.Fprintf(&sb, "%s: %s\n\n", role, messageText(message))
+	}
+	sb.WriteString("Assistant:")
+	return sb.String()
+}
+
+// messageText returns the text content of a message, ignoring non-text parts.
+func messageText(message openai.ChatCompletionMessage) string {
+	if len(message.MultiContent) == 0 {
+		return message.Content
+	}
+	var texts []string
+	for _, part := range message.MultiContent {
+		if part.Type == openai.ChatMessagePartTypeText {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func toCompletionRequest(req openai.ChatCompletionRequest) openai.CompletionRequest {
+	return openai.CompletionRequest{
+		Model:  req.Model,
+		Prompt: completionPrompt(req.Messages),
+		Stop:   req.Stop,
+		Stream: req.Stream,
+		// the completions API only knows max_tokens
+		MaxTokens:        max(req.MaxTokens, req.MaxCompletionTokens),
+		N:                req.N,
+		Temperature:      req.Temperature,
+		TopP:             req.TopP,
+		FrequencyPenalty: req.FrequencyPenalty,
+		PresencePenalty:  req.PresencePenalty,
+		Seed:             req.Seed,
+		LogitBias:        req.LogitBias,
+		User:             req.User,
+	}
+}
+
+// toChatResponse converts a completions response into a chat completion one,
+// so both APIs share the handlers' response mapping.
+func toChatResponse(resp openai.CompletionResponse) openai.ChatCompletionResponse {
+	choices := make([]openai.ChatCompletionChoice, 0, len(resp.Choices))
+	for _, choice := range resp.Choices {
+		choices = append(choices, openai.ChatCompletionChoice{
+			Index: choice.Index,
+			Message: openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: choice.Text,
+			},
+			FinishReason: openai.FinishReason(choice.FinishReason),
+		})
+	}
+	return openai.ChatCompletionResponse{
+		ID:      resp.ID,
+		Object:  resp.Object,
+		Created: resp.Created,
+		Model:   resp.Model,
+		Choices: choices,
+		Usage:   resp.Usage,
+	}
+}
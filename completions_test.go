@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestCompletionPrompt(t *testing.T) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "Be brief."},
+		{Role: openai.ChatMessageRoleUser, Content: "Hi"},
+	}
+	if got, want := completionPrompt(messages), "System: Be brief.\n\nUser: Hi\n\nAssistant:"; got != want {
+		t.Errorf("prompt = %q, want %q", got, want)
+	}
+	if got := completionPrompt(messages[1:]); got != "Hi" {
+		t.Errorf("prompt of a single message = %q, want it as is", got)
+	}
+}
+
+// options.api selects the upstream endpoint, defaulting to chat completions.
+func TestChatUpstreamAPI(t *testing.T) {
+	var paths []string
+	var prompt string
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		var request struct {
+			Prompt string `json:"prompt"`
+			Stream bool   `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		if r.URL.Path != "/v1/completions" {
+			writeTestCompletion(w, "Hi from chat")
+			return
+		}
+		prompt = request.Prompt
+		if request.Stream {
+			writeTestStream(w, `{"id":"cmpl-test","object":"text_completion","created":1,"model":"openai/gpt-4o","choices":[{"index":0,"text":"Hi from completions","finish_reason":"stop"}]}`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"cmpl-test","object":"text_completion","created":1,"model":"openai/gpt-4o","choices":[{"index":0,"text":"Hi from completions","finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":3,"total_tokens":4}}`)
+	})
+
+	tests := []struct {
+		options, stream string
+		wantPath        string
+		wantContent     string
+	}{
+		{`{}`, "false", "/v1/chat/completions", "Hi from chat"},
+		{`{"api":"chat"}`, "false", "/v1/chat/completions", "Hi from chat"},
+		{`{"api":"completions"}`, "false", "/v1/completions", "Hi from completions"},
+		{`{"api":"completions"}`, "true", "/v1/completions", "Hi from completions"},
+	}
+	for _, tt := range tests {
+		paths = nil
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":`+tt.stream+`,"options":`+tt.options+`,"messages":[{"role":"user","content":"Say hi"}]}`)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("options %s: status = %d, body %s", tt.options, recorder.Code, recorder.Body)
+			continue
+		}
+		if len(paths) != 1 || paths[0] != tt.wantPath {
+			t.Errorf("options %s: upstream paths = %q, want %s", tt.options, paths, tt.wantPath)
+		}
+		var content strings.Builder
+		for _, frame := range parseNDJSON(t, recorder.Body.String()) {
+			content.WriteString(frame["message"].(map[string]any)["content"].(string))
+		}
+		if content.String() != tt.wantContent {
+			t.Errorf("options %s, stream %s: content = %q, want %q", tt.options, tt.stream, content.String(), tt.wantContent)
+		}
+	}
+	if prompt != "Say hi" {
+		t.Errorf("completions prompt = %q, want the message", prompt)
+	}
+
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","options":{"api":"responses"},"messages":[{"role":"user","content":"Say hi"}]}`)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("unknown api: status = %d, want 400", recorder.Code)
+	}
+}
+
+// Sampling parameters reach the completions endpoint like the chat one.
+func TestChatUpstreamAPICompletionsSampling(t *testing.T) {
+	var body map[string]any
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"cmpl-test","object":"text_completion","created":1,"model":"openai/gpt-4o","choices":[{"index":0,"text":"Hi","finish_reason":"stop"}]}`)
+	})
+
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":false,"options":{"api":"completions","logit_bias":{"1639":-100},"num_predict":5},"messages":[{"role":"user","content":"Say hi"}]}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	if bias, _ := body["logit_bias"].(map[string]any); bias["1639"] != float64(-100) {
+		t.Errorf("logit_bias = %v, want the request's bias", body["logit_bias"])
+	}
+	if body["max_tokens"] != float64(5) {
+		t.Errorf("max_tokens = %v, want 5", body["max_tokens"])
+	}
+
+	req := toCompletionRequest(openai.ChatCompletionRequest{Temperature: 0.5, TopP: 0.9, FrequencyPenalty: 0.1, PresencePenalty: 0.2, N: 2})
+	if req.Temperature != 0.5 || req.TopP != 0.9 || req.FrequencyPenalty != 0.1 || req.PresencePenalty != 0.2 || req.N != 2 {
+		t.Errorf("completion request = %+v, want the chat request's sampling parameters", req)
+	}
+}
+
+// The user field, or the hash of the caller's key standing in for it, is
+// forwarded whichever endpoint serves the request.
+func TestChatUserForwarded(t *testing.T) {
+	var user any
+	router := newTestRouter(t, routerConfig{apiKeys: []string{"sk-client"}}, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		user = body["user"]
+		if r.URL.Path == "/v1/completions" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":"cmpl-test","object":"text_completion","created":1,"model":"openai/gpt-4o","choices":[{"index":0,"text":"Hi","finish_reason":"stop"}]}`)
+			return
+		}
+		writeTestCompletion(w, "Hi")
+	})
+
+	tests := []struct {
+		api, user string
+		want      string
+	}{
+		{"chat", "alice", "alice"},
+		{"chat", "", apiKeyUser("sk-client")},
+		{"completions", "alice", "alice"},
+		{"completions", "", apiKeyUser("sk-client")},
+	}
+	for _, tt := range tests {
+		user = nil
+		request := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"gpt-4o","stream":false,"user":"`+tt.user+`","options":{"api":"`+tt.api+`"},"messages":[{"role":"user","content":"Say hi"}]}`))
+		request.Header.Set("Authorization", "Bearer sk-client")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Errorf("api %s: status = %d, body %s", tt.api, recorder.Code, recorder.Body)
+			continue
+		}
+		if user != tt.want {
+			t.Errorf("api %s, user %q: upstream user = %v, want %q", tt.api, tt.user, user, tt.want)
+		}
+	}
+}
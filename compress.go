@@ -0,0 +1,120 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// compressor is a gzip or deflate writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compress compresses responses with gzip or deflate if the client accepts
+// it. Flushes are passed through the compressor, so streamed frames still
+// reach the client as they are written.
+func compress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := acceptedEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		// added to, not replacing, e.g. the Vary: Origin of cors
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		defer w.Close()
+		c.Next()
+	}
+}
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip.
+func acceptedEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, value := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok && strings.Trim(q, "0.") == "" {
+			continue
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+// compressWriter starts compressing with the first write, so that responses
+// without a body (e.g. 304) stay empty. Responses already encoded by their
+// handler, or whose headers were sent before, are passed through.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding    string
+	compressor  compressor
+	passThrough bool
+}
+
+func (w *compressWriter) start() {
+	if w.compressor != nil || w.passThrough {
+		return
+	}
+	if w.ResponseWriter.Written() || w.Header().Get("Content-Encoding") != "" {
+		w.passThrough = true
+		return
+	}
+
+	w.Header().Set("Content-Encoding", w.encoding)
+	w.Header().Del("Content-Length")
+	if w.encoding == "gzip" {
+		w.compressor = gzip.NewWriter(w.ResponseWriter)
+	} else {
+		w.compressor, _ = flate.NewWriter(w.ResponseWriter, flate.DefaultCompression)
+	}
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	w.start()
+	if w.passThrough {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.compressor.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Flush() {
+	if w.compressor != nil {
+		w.compressor.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap gives http.ResponseController access to the connection, e.g. for
+// write deadlines.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) Close() {
+	if w.compressor != nil {
+		w.compressor.Close()
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// preflightTimeout bounds the model list request made at startup.
+const preflightTimeout = 10 * time.Second
+
+// getEnvInt parses an integer from the given environment variable, falling
+// back to the default when unset or invalid.
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Invalid integer, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return i
+}
+
+// getEnvBool parses a boolean (e.g. "true", "1") from the given environment
+// variable, falling back to the default when unset or invalid.
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid boolean, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return b
+}
+
+// getEnvDuration parses a duration (e.g. "30s") from the given environment
+// variable, falling back to the default when unset or invalid.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid duration, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return d
+}
+
+// validateConfig checks the upstream settings and loads the filter and
+// aliases files before the proxy starts serving. Problems that would fail
+// every request are returned, doubtful settings are only logged. With
+// preflight, the model list is fetched to check that the upstream is
+// reachable and accepts the API key, unless the models come from a static
+// MODELS_FILE.
+func validateConfig(ctx context.Context, provider *OpenrouterProvider, preflight bool) error {
+	if strings.ContainsAny(provider.apiKey, " \t\r\n\"'") {
+		return errors.New("OPENAI_API_KEY contains whitespace or quotes, check for a stray newline or quoting in your configuration")
+	}
+
+	base, err := url.Parse(provider.baseUrl)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return fmt.Errorf("OPENAI_BASE_URL %q is not an http(s) URL, e.g. https://openrouter.ai/api/v1/", provider.baseUrl)
+	}
+	if base.Hostname() == "openrouter.ai" && !strings.HasPrefix(provider.apiKey, "sk-or-") {
+		slog.Warn("OPENAI_API_KEY doesn't look like an OpenRouter key (sk-or-...)", "baseUrl", provider.baseUrl)
+	}
+	proxy, err := upstreamProxy()
+	if err != nil {
+		return err
+	}
+	if proxy != nil {
+		slog.Info("Sending upstream requests through proxy", "proxy", proxy.Redacted())
+	}
+	tlsConfig, err := upstreamTLSConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil && tlsConfig.InsecureSkipVerify {
+		slog.Warn("INSECURE_SKIP_VERIFY is set: upstream TLS certificates are NOT verified, anyone on the network path can read and alter requests including the API key. Use UPSTREAM_CA_FILE to trust a private CA instead.")
+	}
+
+	if err := loadFilterFile(); err != nil {
+		return fmt.Errorf("loading models filter %s: %w", modelFilterPath, err)
+	}
+	if err := loadEmbeddingsFilterFile(); err != nil {
+		return fmt.Errorf("loading embeddings filter %s: %w", embeddingsFilterPath, err)
+	}
+	if err := loadAliasesFile(); err != nil {
+		return fmt.Errorf("loading model aliases %s: %w", modelAliasesPath, err)
+	}
+
+	if _, static := provider.modelSource.(staticModelSource); !preflight || static {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+	models, err := provider.listModels(ctx)
+	if err != nil {
+		var apiErr *openai.APIError
+		if errors.As(err, &apiErr) && (apiErr.HTTPStatusCode == http.StatusUnauthorized || apiErr.HTTPStatusCode == http.StatusForbidden) {
+			return fmt.Errorf("%s rejected OPENAI_API_KEY: %w", provider.baseUrl, err)
+		}
+		return fmt.Errorf("listing models from %s failed, check OPENAI_BASE_URL or use --skip-preflight to start offline: %w", provider.baseUrl, err)
+	}
+	slog.Info("Upstream reachable", "baseUrl", provider.baseUrl, "models", len(models))
+	return nil
+}
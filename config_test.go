@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withTestConfigFiles points the models filter and aliases files at the
+// given paths for the duration of a test.
+func withTestConfigFiles(t *testing.T, filterPath, aliasesPath string) {
+	t.Helper()
+	withTestFilter(t)
+	withTestAliases(t, nil)
+	previousFilter, previousAliases := modelFilterPath, modelAliasesPath
+	modelFilterPath, modelAliasesPath = filterPath, aliasesPath
+	t.Cleanup(func() { modelFilterPath, modelAliasesPath = previousFilter, previousAliases })
+}
+
+func TestValidateConfigFiles(t *testing.T) {
+	provider, _ := newTestProvider(t, nil)
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	withTestConfigFiles(t, writeTestFile(t, "models-filter", "openai/*\n"), writeTestFile(t, "aliases", "gpt4=openai/gpt-4o\n"))
+	if err := validateConfig(context.Background(), provider, false); err != nil {
+		t.Fatalf("valid files: %v", err)
+	}
+	if !modelFilter.Load().Allows("gpt-4o", "openai/gpt-4o") || modelFilter.Load().Allows("claude-3.5-sonnet", "anthropic/claude-3.5-sonnet") {
+		t.Error("models filter not loaded")
+	}
+	if target, ok := modelAliases.Resolve("gpt4"); !ok || target != "openai/gpt-4o" {
+		t.Errorf("alias gpt4 = %q, %v, want openai/gpt-4o", target, ok)
+	}
+
+	withTestConfigFiles(t, missing, missing)
+	if err := validateConfig(context.Background(), provider, false); err != nil {
+		t.Errorf("missing files: %v, want them to be optional", err)
+	}
+
+	withTestConfigFiles(t, missing, writeTestFile(t, "aliases", "gpt4\n"))
+	if err := validateConfig(context.Background(), provider, false); err == nil || !strings.Contains(err.Error(), "expected alias=model") {
+		t.Errorf("malformed aliases: err = %v, want the parse error", err)
+	}
+}
+
+// The upstream isn't checked when it doesn't provide the models.
+func TestValidateConfigPreflightStaticModels(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	withTestConfigFiles(t, missing, missing)
+
+	for _, source := range []string{"upstream", "static"} {
+		provider, listed := newTestProvider(t, nil)
+		modelSource, err := newModelSource(source, writeTestFile(t, "models.json", `[{"id": "local/phi3"}]`), provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		provider.modelSource = modelSource
+		if err := validateConfig(context.Background(), provider, true); err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		if want := map[string]int32{"upstream": 1, "static": 0}[source]; listed.Load() != want {
+			t.Errorf("%s: upstream listed %d times, want %d", source, listed.Load(), want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ContentPolicy controls which parts of an upstream assistant message end up
+// in the content returned to the client.
+type ContentPolicy string
+
+const (
+	// ContentPolicyContent returns only the regular content.
+	ContentPolicyContent ContentPolicy = "content"
+	// ContentPolicyContentRefusal appends refusal text to the content, so
+	// refused requests don't show up as empty responses.
+	ContentPolicyContentRefusal ContentPolicy = "content+refusal"
+)
+
+var contentPolicy = ContentPolicyContent
+
+func parseContentPolicy(value string) (ContentPolicy, error) {
+	switch policy := ContentPolicy(value); policy {
+	case "":
+		return ContentPolicyContent, nil
+	case ContentPolicyContent, ContentPolicyContentRefusal:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown content policy %q", value)
+	}
+}
+
+// assembleContent combines the fields of a message (or a streamed delta) into
+// the content sent to the client. Streaming and non-streaming responses both
+// go through here, so concatenated deltas equal the non-streamed content.
+func assembleContent(content string, refusal string) string {
+	switch contentPolicy {
+	case ContentPolicyContentRefusal:
+		return content + refusal
+	default:
+		return content
+	}
+}
+
+// trimTrailingWhitespace removes whitespace from the end of responses.
+var trimTrailingWhitespace bool
+
+func trimTrailing(content string) string {
+	if !trimTrailingWhitespace {
+		return content
+	}
+	return strings.TrimRightFunc(content, unicode.IsSpace)
+}
+
+// trailingWhitespace trims the end of a streamed response. Since any delta
+// could be the last one, whitespace at the end of a delta is held back until
+// more content follows it and dropped if none does.
+type trailingWhitespace struct {
+	pending string
+}
+
+func (t *trailingWhitespace) next(content string) string {
+	if !trimTrailingWhitespace {
+		return content
+	}
+	content = t.pending + content
+	trimmed := trimTrailing(content)
+	t.pending = content[len(trimmed):]
+	return trimmed
+}
+
+// EmptyResponsePolicy controls what is returned when the upstream succeeds but
+// produces no content, refusal or tool calls at all.
+type EmptyResponsePolicy string
+
+const (
+	// EmptyResponseBlank returns the empty content as is.
+	EmptyResponseBlank EmptyResponsePolicy = "blank"
+	// EmptyResponsePlaceholder substitutes emptyResponsePlaceholder.
+	EmptyResponsePlaceholder EmptyResponsePolicy = "placeholder"
+	// EmptyResponseError fails the request with errEmptyResponse.
+	EmptyResponseError EmptyResponsePolicy = "error"
+)
+
+var errEmptyResponse = errors.New("model returned an empty response")
+
+var (
+	emptyResponsePolicy      = EmptyResponseBlank
+	emptyResponsePlaceholder string
+)
+
+// parseEmptyResponsePolicy defaults to the placeholder policy when only a
+// placeholder text is configured.
+func parseEmptyResponsePolicy(value string, placeholder string) (EmptyResponsePolicy, error) {
+	switch policy := EmptyResponsePolicy(value); policy {
+	case "":
+		if placeholder != "" {
+			return EmptyResponsePlaceholder, nil
+		}
+		return EmptyResponseBlank, nil
+	case EmptyResponsePlaceholder:
+		if placeholder == "" {
+			return "", errors.New("placeholder policy requires EMPTY_RESPONSE_PLACEHOLDER")
+		}
+		return policy, nil
+	case EmptyResponseBlank, EmptyResponseError:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown empty response policy %q", value)
+	}
+}
+
+// finishReasons returns the finish_reason and Ollama done_reason of a finished
+// response, shared by the streaming and non-streaming paths. The reason only
+// defaults to "stop" when the backend didn't report one. Tool calls end a turn
+// like a regular stop in Ollama; truncation and filtering are passed through
+// so clients can tell them apart.
+func finishReasons(reason openai.FinishReason) (finishReason string, doneReason string) {
+	if reason == "" {
+		reason = openai.FinishReasonStop
+	}
+
+	switch reason {
+	case openai.FinishReasonStop, openai.FinishReasonToolCalls, openai.FinishReasonFunctionCall:
+		return string(reason), "stop"
+	default:
+		return string(reason), string(reason)
+	}
+}
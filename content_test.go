@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestFinishReasons(t *testing.T) {
+	tests := []struct {
+		reason                           openai.FinishReason
+		wantFinishReason, wantDoneReason string
+	}{
+		{"", "stop", "stop"},
+		{openai.FinishReasonStop, "stop", "stop"},
+		{openai.FinishReasonLength, "length", "length"},
+		{openai.FinishReasonContentFilter, "content_filter", "content_filter"},
+		{openai.FinishReasonToolCalls, "tool_calls", "stop"},
+	}
+	for _, tt := range tests {
+		finishReason, doneReason := finishReasons(tt.reason)
+		if finishReason != tt.wantFinishReason || doneReason != tt.wantDoneReason {
+			t.Errorf("finishReasons(%q) = %q, %q, want %q, %q", tt.reason, finishReason, doneReason, tt.wantFinishReason, tt.wantDoneReason)
+		}
+	}
+}
+
+// A truncated response reports its reason in both the streamed and the
+// non-streamed response.
+func TestChatFinishReasonLength(t *testing.T) {
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		var request openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Stream {
+			writeTestStream(w, testChunk(`[{"index":0,"delta":{"role":"assistant","content":"Once upon"},"finish_reason":"length"}]`, ""))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id": "chatcmpl-test", "object": "chat.completion", "created": 1, "model": "openai/gpt-4o",
+			"choices": []map[string]any{{"index": 0, "message": map[string]any{"role": "assistant", "content": "Once upon"}, "finish_reason": "length"}},
+		})
+	})
+
+	for _, stream := range []string{"true", "false"} {
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":`+stream+`,"messages":[{"role":"user","content":"Tell a story"}]}`)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("stream %s: status = %d, body %s", stream, recorder.Code, recorder.Body)
+		}
+		frames := parseNDJSON(t, recorder.Body.String())
+		final := frames[len(frames)-1]
+		if final["done"] != true || final["finish_reason"] != "length" || final["done_reason"] != "length" {
+			t.Errorf("stream %s: final frame = %v, want finish and done reason length", stream, final)
+		}
+	}
+}
+
+// The same upstream response assembles to the same content and thinking,
+// whether streamed or not.
+func TestChatAssembledContentMatches(t *testing.T) {
+	for _, policy := range []ContentPolicy{ContentPolicyContent, ContentPolicyContentRefusal} {
+		t.Run(string(policy), func(t *testing.T) {
+			previous := contentPolicy
+			contentPolicy = policy
+			t.Cleanup(func() { contentPolicy = previous })
+
+			router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+				var request openai.ChatCompletionRequest
+				json.NewDecoder(r.Body).Decode(&request)
+				if request.Stream {
+					writeTestStream(w,
+						testChunk(`[{"index":0,"delta":{"role":"assistant","reasoning":"Weighing "}}]`, ""),
+						testChunk(`[{"index":0,"delta":{"reasoning":"it up.","content":"Partly "}}]`, ""),
+						testChunk(`[{"index":0,"delta":{"content":"sure.","refusal":" Can't say more."},"finish_reason":"stop"}]`, ""),
+					)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(map[string]any{
+					"id": "chatcmpl-test", "object": "chat.completion", "created": 1, "model": "openai/gpt-4o",
+					"choices": []map[string]any{{"index": 0, "finish_reason": "stop", "message": map[string]any{
+						"role": "assistant", "content": "Partly sure.", "refusal": " Can't say more.", "reasoning": "Weighing it up.",
+					}}},
+				})
+			})
+
+			body := `{"model":"gpt-4o","stream":%s,"messages":[{"role":"user","content":"Sure?"}]}`
+			recorder := serveTestRequest(router, http.MethodPost, "/api/chat", fmt.Sprintf(body, "false"))
+			var response struct {
+				Message map[string]string `json:"message"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+
+			recorder = serveTestRequest(router, http.MethodPost, "/api/chat", fmt.Sprintf(body, "true"))
+			var content, thinking strings.Builder
+			for _, frame := range parseNDJSON(t, recorder.Body.String()) {
+				message := frame["message"].(map[string]any)
+				content.WriteString(message["content"].(string))
+				if frame["done"] != true && message["thinking"] != nil {
+					thinking.WriteString(message["thinking"].(string))
+				}
+			}
+
+			if content.String() != response.Message["content"] {
+				t.Errorf("streamed content %q, non-streamed %q", content.String(), response.Message["content"])
+			}
+			if thinking.String() != response.Message["thinking"] || thinking.String() != "Weighing it up." {
+				t.Errorf("streamed thinking %q, non-streamed %q", thinking.String(), response.Message["thinking"])
+			}
+		})
+	}
+}
+
+// withEmptyResponsePolicy sets the empty response policy for the duration of
+// a test.
+func withEmptyResponsePolicy(t *testing.T, policy EmptyResponsePolicy, placeholder string) {
+	t.Helper()
+	previousPolicy, previousPlaceholder := emptyResponsePolicy, emptyResponsePlaceholder
+	emptyResponsePolicy, emptyResponsePlaceholder = policy, placeholder
+	t.Cleanup(func() { emptyResponsePolicy, emptyResponsePlaceholder = previousPolicy, previousPlaceholder })
+}
+
+// newEmptyResponseRouter serves a backend answering every chat with nothing.
+func newEmptyResponseRouter(t *testing.T) http.Handler {
+	return newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		var request openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Stream {
+			writeTestStream(w, testChunk(`[{"index":0,"delta":{"role":"assistant","content":""},"finish_reason":"stop"}]`, ""))
+			return
+		}
+		writeTestCompletion(w, "")
+	})
+}
+
+func TestChatEmptyResponsePlaceholder(t *testing.T) {
+	withEmptyResponsePolicy(t, EmptyResponsePlaceholder, "(no response)")
+	router := newEmptyResponseRouter(t)
+
+	body := `{"model":"gpt-4o","stream":%s,"messages":[{"role":"user","content":"Hi"}]}`
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", fmt.Sprintf(body, "false"))
+	if !strings.Contains(recorder.Body.String(), `"content":"(no response)"`) {
+		t.Errorf("non-streamed response = %s, want the placeholder", recorder.Body)
+	}
+
+	recorder = serveTestRequest(router, http.MethodPost, "/api/chat", fmt.Sprintf(body, "true"))
+	var content strings.Builder
+	for _, frame := range parseNDJSON(t, recorder.Body.String()) {
+		content.WriteString(frame["message"].(map[string]any)["content"].(string))
+	}
+	if content.String() != "(no response)" {
+		t.Errorf("streamed content = %q, want the placeholder", content.String())
+	}
+}
+
+func TestChatEmptyResponseError(t *testing.T) {
+	withEmptyResponsePolicy(t, EmptyResponseError, "")
+	router := newEmptyResponseRouter(t)
+
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"Hi"}]}`)
+	if recorder.Code != http.StatusBadGateway || !strings.Contains(recorder.Body.String(), errEmptyResponse.Error()) {
+		t.Errorf("status = %d, body %s, want a 502 empty response error", recorder.Code, recorder.Body)
+	}
+}
+
+func TestChatEmptyResponseBlank(t *testing.T) {
+	withEmptyResponsePolicy(t, EmptyResponseBlank, "")
+	router := newEmptyResponseRouter(t)
+
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"Hi"}]}`)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"content":""`) {
+		t.Errorf("status = %d, body %s, want the blank content", recorder.Code, recorder.Body)
+	}
+}
+
+func withTrimTrailingWhitespace(t *testing.T) {
+	t.Helper()
+	previous := trimTrailingWhitespace
+	trimTrailingWhitespace = true
+	t.Cleanup(func() { trimTrailingWhitespace = previous })
+}
+
+// Whitespace between deltas is kept, only that at the very end is dropped.
+func TestTrailingWhitespaceStream(t *testing.T) {
+	withTrimTrailingWhitespace(t)
+
+	deltas := []string{"Line one.\n", "\n", "  Line two:", " ", "\tindented\n", "\n  ", ""}
+	var trailing trailingWhitespace
+	var content strings.Builder
+	for _, delta := range deltas {
+		content.WriteString(trailing.next(delta))
+	}
+	if want := "Line one.\n\n  Line two: \tindented"; content.String() != want {
+		t.Errorf("content = %q, want %q", content.String(), want)
+	}
+}
+
+func TestChatTrimTrailingWhitespace(t *testing.T) {
+	withTrimTrailingWhitespace(t)
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		var request openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Stream {
+			writeTestStream(w,
+				testChunk(`[{"index":0,"delta":{"role":"assistant","content":"- a\n "}}]`, ""),
+				testChunk(`[{"index":0,"delta":{"content":" - b\n\n"}}]`, ""),
+				testChunk(`[{"index":0,"delta":{"content":"  \n"},"finish_reason":"stop"}]`, ""),
+			)
+			return
+		}
+		writeTestCompletion(w, "- a\n  - b\n\n  \n")
+	})
+
+	body := `{"model":"gpt-4o","stream":%s,"messages":[{"role":"user","content":"List"}]}`
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", fmt.Sprintf(body, "false"))
+	var response struct {
+		Message map[string]string `json:"message"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Message["content"] != "- a\n  - b" {
+		t.Errorf("non-streamed content = %q, want %q", response.Message["content"], "- a\n  - b")
+	}
+
+	recorder = serveTestRequest(router, http.MethodPost, "/api/chat", fmt.Sprintf(body, "true"))
+	var content strings.Builder
+	for _, frame := range parseNDJSON(t, recorder.Body.String()) {
+		content.WriteString(frame["message"].(map[string]any)["content"].(string))
+	}
+	if content.String() != "- a\n  - b" {
+		t.Errorf("streamed content = %q, want %q", content.String(), "- a\n  - b")
+	}
+}
+
+// A filtered response keeps the content produced before the filter stopped
+// it, and reports the filtering, both streamed and not.
+func TestChatContentFilter(t *testing.T) {
+	// the placeholder must not replace even an empty filtered response
+	withEmptyResponsePolicy(t, EmptyResponsePlaceholder, "(no response)")
+	content := "Here is how"
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		var request openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Stream {
+			writeTestStream(w,
+				testChunk(`[{"index":0,"delta":{"role":"assistant","content":"`+content+`"}}]`, ""),
+				testChunk(`[{"index":0,"delta":{"content":""},"finish_reason":"content_filter"}]`, ""),
+			)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id": "chatcmpl-test", "object": "chat.completion", "created": 1, "model": "openai/gpt-4o",
+			"choices": []map[string]any{{"index": 0, "message": map[string]any{"role": "assistant", "content": content}, "finish_reason": "content_filter"}},
+		})
+	})
+
+	for _, partial := range []string{"Here is how", ""} {
+		content = partial
+		for _, stream := range []string{"false", "true"} {
+			recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":`+stream+`,"messages":[{"role":"user","content":"How?"}]}`)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("stream %s: status = %d, body %s", stream, recorder.Code, recorder.Body)
+			}
+			var got strings.Builder
+			frames := parseNDJSON(t, recorder.Body.String())
+			for _, frame := range frames {
+				got.WriteString(frame["message"].(map[string]any)["content"].(string))
+			}
+			final := frames[len(frames)-1]
+			if got.String() != partial || final["done_reason"] != "content_filter" || final["finish_reason"] != "content_filter" {
+				t.Errorf("stream %s: content = %q, final frame %v, want %q filtered", stream, got.String(), final, partial)
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxLoggedBody is how much of a request or response body is logged.
+const maxLoggedBody = 64 << 10
+
+const redacted = "[REDACTED]"
+
+// redactedHeaders carry credentials and are never logged.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "X-Api-Key", "Cookie"}
+
+// redactedFields are JSON fields whose values are never logged, matched
+// case-insensitively at any depth.
+var redactedFields = map[string]bool{
+	"api_key":       true,
+	"apikey":        true,
+	"authorization": true,
+	"password":      true,
+	"secret":        true,
+	"token":         true,
+	"access_token":  true,
+}
+
+// defaultRedactPatterns mask personal data and keys in logged text, e.g. in
+// messages: email addresses, and API keys and bearer tokens of common shapes.
+var defaultRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2}[A-Za-z]*`),
+	regexp.MustCompile(`\b(sk|pk|rk)-[A-Za-z0-9_-]{16}[A-Za-z0-9_-]*`),
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`),
+}
+
+// parseRedactPatterns parses DEBUG_LOG_REDACT_PATTERNS, comma-separated
+// regular expressions masked in logged bodies in addition to the default ones.
+func parseRedactPatterns(value string) ([]*regexp.Regexp, error) {
+	patterns := slices.Clone(defaultRedactPatterns)
+	for _, expr := range strings.Split(value, ",") {
+		if expr = strings.TrimSpace(expr); expr == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", expr, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// logBodies logs the body of every request and response at debug level, with
+// credentials redacted and text matching patterns masked. Streamed responses
+// are summarized by their number of frames and the final frame instead of
+// logging each frame.
+func logBodies(patterns []*regexp.Regexp) gin.HandlerFunc {
+	redactBody := func(body []byte) string {
+		return redactBody(body, patterns)
+	}
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		if c.Request.Body != nil {
+			head, err := io.ReadAll(io.LimitReader(c.Request.Body, maxLoggedBody))
+			if err != nil {
+				slog.DebugContext(ctx, "Failed to read request body for logging", "Error", err)
+			}
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(head), c.Request.Body), c.Request.Body}
+			slog.DebugContext(ctx, "Request body",
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"headers", redactHeaders(c.Request.Header),
+				"body", redactBody(head),
+			)
+		}
+
+		w := &bodyLogWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		if strings.HasPrefix(w.Header().Get("Content-Type"), "application/x-ndjson") {
+			slog.DebugContext(ctx, "Streamed response",
+				"status", w.Status(),
+				"frames", w.frames,
+				"bytes", w.size,
+				"final", redactBody(bytes.TrimSpace(w.last)),
+			)
+			return
+		}
+		slog.DebugContext(ctx, "Response body",
+			"status", w.Status(),
+			"body", redactBody(w.body.Bytes()),
+		)
+	}
+}
+
+func redactHeaders(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range redactedHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, redacted)
+		}
+	}
+	return header
+}
+
+// redactBody returns a JSON body with credential fields redacted and text
+// matching patterns masked in its strings. Bodies that aren't JSON are masked
+// as a whole.
+func redactBody(body []byte, patterns []*regexp.Regexp) string {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return redactText(string(body), patterns)
+	}
+	redacted, err := json.Marshal(redactValue(value, patterns))
+	if err != nil {
+		return redactText(string(body), patterns)
+	}
+	return string(redacted)
+}
+
+func redactValue(value any, patterns []*regexp.Regexp) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if redactedFields[strings.ToLower(key)] {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(field, patterns)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item, patterns)
+		}
+	case string:
+		return redactText(v, patterns)
+	}
+	return value
+}
+
+func redactText(text string, patterns []*regexp.Regexp) string {
+	for _, pattern := range patterns {
+		text = pattern.ReplaceAllString(text, redacted)
+	}
+	return text
+}
+
+// bodyLogWriter keeps a copy of the response for logging: the start of a
+// non-streamed body, or the frame count and last frame of an NDJSON stream.
+type bodyLogWriter struct {
+	gin.ResponseWriter
+	body    bytes.Buffer
+	frames  int
+	size    int
+	pending []byte
+	last    []byte
+}
+
+func (w *bodyLogWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.record(data[:n])
+	return n, err
+}
+
+func (w *bodyLogWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *bodyLogWriter) record(data []byte) {
+	w.size += len(data)
+	if room := maxLoggedBody - w.body.Len(); room > 0 {
+		w.body.Write(data[:min(room, len(data))])
+	}
+
+	w.pending = append(w.pending, data...)
+	for {
+		line, rest, ok := bytes.Cut(w.pending, []byte("\n"))
+		if !ok {
+			break
+		}
+		w.frames++
+		w.last = append(w.last[:0], line...)
+		w.pending = append(w.pending[:0], rest...)
+	}
+}
+
+// Unwrap gives http.ResponseController access to the connection, e.g. for
+// write deadlines.
+func (w *bodyLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
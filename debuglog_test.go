@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// captureLogs sends the default logger's output, at every level, to the
+// returned buffer for the duration of a test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &logs
+}
+
+func TestLogBodiesRedactsPatterns(t *testing.T) {
+	logs := captureLogs(t)
+	patterns, err := parseRedactPatterns(`\bACCT-\d+\b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(logBodies(patterns))
+	r.POST("/api/chat", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": gin.H{"content": "I emailed jane.doe@example.com for you"}})
+	})
+
+	body := `{"messages":[{"role":"user","content":"Mail jane.doe@example.com about ACCT-12345, key sk-or-v1-abcdefghijklmnopqrstuvwxyz"}],"api_key":"hunter2"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret-token")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	out := logs.String()
+	for _, leaked := range []string{"jane.doe@example.com", "ACCT-12345", "abcdefghijklmnopqrstuvwxyz", "hunter2", "secret-token"} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("logs contain %q:\n%s", leaked, out)
+		}
+	}
+	for _, kept := range []string{"Mail ", " about ", "I emailed ", " for you"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("logs lack the surrounding text %q:\n%s", kept, out)
+		}
+	}
+}
+
+func TestParseRedactPatterns(t *testing.T) {
+	patterns, err := parseRedactPatterns(" a+ , ,b\\x2cc ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(patterns), len(defaultRedactPatterns)+2; got != want {
+		t.Errorf("got %d patterns, want %d", got, want)
+	}
+	if got := redactText("xaay b,c", patterns); got != "x"+redacted+"y "+redacted {
+		t.Errorf("redactText = %q", got)
+	}
+	if _, err := parseRedactPatterns("(unclosed"); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Echo responses are paced like a fast backend: a delay until the first
+// token, then one per word.
+const (
+	echoFirstTokenDelay = 200 * time.Millisecond
+	echoTokenDelay      = 20 * time.Millisecond
+)
+
+// echoModelID is the model listed in echo mode. Requests may name any other
+// model as well, which is echoed all the same.
+const echoModelID = "echo"
+
+// echoTransport answers chat and completion requests itself with the last
+// user message (or the prompt), so that clients can be tested against the
+// proxy without spending tokens. The model list is answered too, so that no
+// backend is needed. Other requests, e.g. for embeddings, are passed to the
+// backend.
+type echoTransport struct {
+	base http.RoundTripper
+}
+
+func (t echoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := req.URL.Path
+	if req.Method == http.MethodGet && strings.HasSuffix(path, "/models") {
+		encoded, err := json.Marshal(map[string]any{
+			"object": "list",
+			"data":   []map[string]any{{"id": echoModelID, "object": "model", "owned_by": "echo"}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return echoResponse(req, "application/json", io.NopCloser(bytes.NewReader(encoded))), nil
+	}
+	if req.Method != http.MethodPost || !strings.HasSuffix(path, "/completions") {
+		return t.base.RoundTrip(req)
+	}
+	chat := strings.HasSuffix(path, "/chat/completions")
+
+	var body struct {
+		Model    string                         `json:"model"`
+		Messages []openai.ChatCompletionMessage `json:"messages"`
+		Prompt   string                         `json:"prompt"`
+		Stream   bool                           `json:"stream"`
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+
+	text, prompt := body.Prompt, []openai.ChatCompletionMessage{{Content: body.Prompt}}
+	if chat {
+		text, prompt = lastUserMessage(body.Messages), body.Messages
+	}
+	words := strings.SplitAfter(text, " ")
+	usage := openai.Usage{
+		PromptTokens:     estimatePromptTokens(prompt, body.Model),
+		CompletionTokens: len(words),
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	id := "echo-" + newRequestID()
+	created := time.Now().Unix()
+	if !body.Stream {
+		if err := sleepContext(req.Context(), echoFirstTokenDelay+time.Duration(len(words))*echoTokenDelay); err != nil {
+			return nil, err
+		}
+		var resp any
+		if chat {
+			resp = openai.ChatCompletionResponse{
+				ID: id, Object: "chat.completion", Created: created, Model: body.Model,
+				Choices: []openai.ChatCompletionChoice{{
+					Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: text},
+					FinishReason: openai.FinishReasonStop,
+				}},
+				Usage: usage,
+			}
+		} else {
+			resp = openai.CompletionResponse{
+				ID: id, Object: "text_completion", Created: created, Model: body.Model,
+				Choices: []openai.CompletionChoice{{Text: text, FinishReason: string(openai.FinishReasonStop)}},
+				Usage:   usage,
+			}
+		}
+		encoded, err := json.Marshal(resp)
+		if err != nil {
+			return nil, err
+		}
+		return echoResponse(req, "application/json", io.NopCloser(bytes.NewReader(encoded))), nil
+	}
+
+	// chunks carry only the fields clients read, in the shape of either API
+	chunk := func(delta string, finishReason any, usage *openai.Usage) map[string]any {
+		choice := map[string]any{"index": 0, "finish_reason": finishReason}
+		if chat {
+			choice["delta"] = map[string]string{"role": openai.ChatMessageRoleAssistant, "content": delta}
+		} else {
+			choice["text"] = delta
+		}
+		frame := map[string]any{"id": id, "created": created, "model": body.Model, "choices": []any{choice}}
+		if usage != nil {
+			frame["choices"] = []any{}
+			frame["usage"] = usage
+		}
+		return frame
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		send := func(frame any) error {
+			encoded, err := json.Marshal(frame)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(writer, "data: %s\n\n", encoded)
+			return err
+		}
+		err := sleepContext(req.Context(), echoFirstTokenDelay)
+		for i, word := range words {
+			if err != nil {
+				break
+			}
+			if i > 0 {
+				err = sleepContext(req.Context(), echoTokenDelay)
+			}
+			if err == nil {
+				err = send(chunk(word, nil, nil))
+			}
+		}
+		if err == nil {
+			err = send(chunk("", openai.FinishReasonStop, nil))
+		}
+		if err == nil {
+			err = send(chunk("", nil, &usage))
+		}
+		if err == nil {
+			_, err = io.WriteString(writer, "data: [DONE]\n\n")
+		}
+		writer.CloseWithError(err)
+	}()
+	return echoResponse(req, "text/event-stream", reader), nil
+}
+
+func echoResponse(req *http.Request, contentType string, body io.ReadCloser) *http.Response {
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {contentType}},
+		Body:       body,
+		Request:    req,
+	}
+}
+
+func lastUserMessage(messages []openai.ChatCompletionMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == openai.ChatMessageRoleUser {
+			return messageText(messages[i])
+		}
+	}
+	return ""
+}
+
+// sleepContext waits for the duration, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// Echo mode needs no backend, neither for chat nor for the model list.
+func TestEchoModeWithoutBackend(t *testing.T) {
+	t.Setenv("ECHO_MODE", "true")
+	t.Setenv("UPSTREAM_MAX_ATTEMPTS", "1")
+	withTestFilter(t)
+	// nothing listens on the discard port
+	provider := NewOpenrouterProvider("http://127.0.0.1:9/v1", "")
+	router := newRouter(context.Background(), routerConfig{}, provider, &ProviderRoutes{fallback: provider})
+
+	models := tagsModels(t, router)
+	if len(models) != 1 || models[0]["model"] != echoModelID {
+		t.Errorf("tags = %v, want the echo model", models)
+	}
+
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"Hello there"}]}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	var response struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Message.Content != "Hello there" {
+		t.Errorf("content = %q, want the user message echoed", response.Message.Content)
+	}
+}
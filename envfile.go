@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultEnvFile is loaded if it exists, unless --env-file names another file.
+const defaultEnvFile = ".env"
+
+// envFileFlag returns the value of the --env-file flag, and whether it was
+// given. It is read before the other flags, whose defaults may come from the
+// environment the file sets.
+func envFileFlag(args []string) (string, bool) {
+	for i, arg := range args[1:] {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "env-file" {
+			continue
+		}
+		if hasValue {
+			return value, true
+		}
+		if i+2 < len(args) {
+			return args[i+2], true
+		}
+	}
+	return defaultEnvFile, false
+}
+
+// loadEnvFile sets the environment variables defined in a .env file, one
+// KEY=value per line, that aren't set already. Blank lines and lines starting
+// with # are skipped, an "export " prefix is allowed and values may be quoted.
+// A missing file is only an error if required. It returns the number of
+// variables set.
+func loadEnvFile(path string, required bool) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer file.Close()
+
+	set := 0
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return set, fmt.Errorf("%s:%d: expected KEY=value", path, lineNumber)
+		}
+		if _, exists := os.LookupEnv(key); exists {
+			continue
+		}
+		if err := os.Setenv(key, envFileValue(value)); err != nil {
+			return set, err
+		}
+		set++
+	}
+	return set, scanner.Err()
+}
+
+// envFileValue unquotes a value of a .env file. Unquoted values end at a " #"
+// comment.
+func envFileValue(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+var errUpstreamTimeout = errors.New("upstream request timed out")
+
+// upstreamError maps an error returned by the upstream API to the HTTP status
+// and message reported to the client. Client errors of the upstream, e.g. 401
+// for a rejected key, 404 for an unknown model or 429 when rate limited, keep
+// their status so that clients can act on them; server errors become 502.
+func upstreamError(err error) (int, string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout, errUpstreamTimeout.Error()
+	}
+	if errors.Is(err, errCircuitOpen) {
+		return http.StatusServiceUnavailable, errCircuitOpen.Error()
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode != 0 && apiErr.Message != "" {
+		return upstreamStatus(apiErr.HTTPStatusCode), apiErr.Message
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode != 0 {
+		return upstreamStatus(reqErr.HTTPStatusCode), reqErr.Err.Error()
+	}
+	return http.StatusInternalServerError, err.Error()
+}
+
+func upstreamStatus(status int) int {
+	if status >= http.StatusBadRequest && status < http.StatusInternalServerError {
+		return status
+	}
+	return http.StatusBadGateway
+}
+
+// upstreamErrorDetails returns the code and type of an error reported by the
+// upstream API, e.g. "rate_limit_exceeded" and "requests", if any.
+func upstreamErrorDetails(err error) (code any, errorType string) {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code, apiErr.Type
+	}
+	return nil, ""
+}
+
+// writeUpstreamError responds to a failed upstream call with an Ollama error,
+// plus the upstream error's code and type for debugging.
+func writeUpstreamError(c *gin.Context, err error) {
+	status, message := upstreamError(err)
+	body := gin.H{"error": message}
+	code, errorType := upstreamErrorDetails(err)
+	if code != nil {
+		body["code"] = code
+	}
+	if errorType != "" {
+		body["type"] = errorType
+	}
+	c.JSON(status, body)
+}
+
+// writeOpenAIUpstreamError responds to a failed upstream call of a /v1
+// endpoint with an OpenAI error, keeping the upstream error's code and type.
+func writeOpenAIUpstreamError(c *gin.Context, err error) {
+	status, message := upstreamError(err)
+	body := openAIError(status, message)
+	code, errorType := upstreamErrorDetails(err)
+	if code != nil {
+		body["error"].(gin.H)["code"] = code
+	}
+	if errorType != "" {
+		body["error"].(gin.H)["type"] = errorType
+	}
+	c.JSON(status, body)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestUpstreamError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantMessage string
+	}{
+		{"unauthorized", &openai.APIError{HTTPStatusCode: 401, Message: "invalid api key"}, http.StatusUnauthorized, "invalid api key"},
+		{"rate limited", &openai.APIError{HTTPStatusCode: 429, Message: "slow down"}, http.StatusTooManyRequests, "slow down"},
+		{"server error", &openai.APIError{HTTPStatusCode: 500, Message: "internal error"}, http.StatusBadGateway, "internal error"},
+		{"unavailable", &openai.APIError{HTTPStatusCode: 503, Message: "overloaded"}, http.StatusBadGateway, "overloaded"},
+		{"wrapped", fmt.Errorf("chat: %w", &openai.APIError{HTTPStatusCode: 404, Message: "no such model"}), http.StatusNotFound, "no such model"},
+		{"request error", &openai.RequestError{HTTPStatusCode: 502, Err: errors.New("bad gateway")}, http.StatusBadGateway, "bad gateway"},
+		{"timeout", fmt.Errorf("chat: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, errUpstreamTimeout.Error()},
+		{"circuit open", errCircuitOpen, http.StatusServiceUnavailable, errCircuitOpen.Error()},
+		{"other", errors.New("boom"), http.StatusInternalServerError, "boom"},
+	}
+	for _, tt := range tests {
+		status, message := upstreamError(tt.err)
+		if status != tt.wantStatus || message != tt.wantMessage {
+			t.Errorf("%s: upstreamError = %d %q, want %d %q", tt.name, status, message, tt.wantStatus, tt.wantMessage)
+		}
+	}
+}
+
+var testRateLimitError = &openai.APIError{HTTPStatusCode: 429, Message: "slow down", Code: "rate_limit_exceeded", Type: "requests"}
+
+func TestWriteUpstreamError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	writeUpstreamError(c, testRateLimitError)
+
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", recorder.Code)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != "slow down" || body["code"] != "rate_limit_exceeded" || body["type"] != "requests" {
+		t.Errorf("body = %v, want the message, code and type", body)
+	}
+}
+
+func TestWriteOpenAIUpstreamError(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	writeOpenAIUpstreamError(c, testRateLimitError)
+
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", recorder.Code)
+	}
+	var body struct {
+		Error map[string]any `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error["message"] != "slow down" || body.Error["code"] != "rate_limit_exceeded" || body.Error["type"] != "requests" {
+		t.Errorf("error = %v, want the message, code and type", body.Error)
+	}
+}
+
+// Errors of the upstream reach the client with their status, both parsed
+// from the upstream response and for server errors.
+func TestChatUpstreamErrors(t *testing.T) {
+	t.Setenv("UPSTREAM_MAX_ATTEMPTS", "1")
+	tests := []struct {
+		status     int
+		wantStatus int
+	}{
+		{http.StatusUnauthorized, http.StatusUnauthorized},
+		{http.StatusTooManyRequests, http.StatusTooManyRequests},
+		{http.StatusInternalServerError, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(tt.status)
+			fmt.Fprintf(w, `{"error":{"message":"upstream says %d","type":"test_error","code":"code_%d"}}`, tt.status, tt.status)
+		})
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"Hi"}]}`)
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("upstream %d: status = %d, want %d", tt.status, recorder.Code, tt.wantStatus)
+			continue
+		}
+		var body map[string]any
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body["error"] != fmt.Sprintf("upstream says %d", tt.status) || body["code"] != fmt.Sprintf("code_%d", tt.status) || body["type"] != "test_error" {
+			t.Errorf("upstream %d: body = %v, want its message, code and type", tt.status, body)
+		}
+	}
+}
+
+// A failing model lookup on /api/chat keeps the upstream's status instead of
+// turning into a 404.
+func TestChatModelLookupError(t *testing.T) {
+	t.Setenv("UPSTREAM_MAX_ATTEMPTS", "1")
+	withTestFilter(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":{"message":"invalid api key","type":"auth_error"}}`)
+	}))
+	t.Cleanup(server.Close)
+	provider := NewOpenrouterProvider(server.URL+"/v1", "sk-test")
+	router := newRouter(context.Background(), routerConfig{}, provider, &ProviderRoutes{fallback: provider})
+
+	for _, body := range []string{
+		`{"model":"gpt-4o","messages":[]}`,
+		`{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"Hi"}]}`,
+		`{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`,
+	} {
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", body)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, body %s, want 401", body, recorder.Code, recorder.Body)
+		}
+	}
+}
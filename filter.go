@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ModelFilter holds the entries of a models-filter file. Plain lines are
+// matched exactly, lines containing '*' or '?' are compiled to patterns.
+// Lines prefixed with '!' form a blocklist, which takes precedence over the
+// allowlist.
+type ModelFilter struct {
+	entries []string
+	allow   matcher
+	block   matcher
+}
+
+type matcher struct {
+	exact    map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+func NewModelFilter(entries []string) *ModelFilter {
+	filter := &ModelFilter{
+		allow: matcher{exact: make(map[string]struct{})},
+		block: matcher{exact: make(map[string]struct{})},
+	}
+	for _, entry := range entries {
+		filter.add(entry)
+	}
+	return filter
+}
+
+func (f *ModelFilter) add(entry string) {
+	f.entries = append(f.entries, entry)
+	if blocked, ok := strings.CutPrefix(entry, "!"); ok {
+		f.block.add(strings.TrimSpace(blocked))
+		return
+	}
+	f.allow.add(entry)
+}
+
+func (m *matcher) add(entry string) {
+	if !strings.ContainsAny(entry, "*?") {
+		m.exact[entry] = struct{}{}
+		return
+	}
+	m.patterns = append(m.patterns, compileGlob(entry))
+}
+
+func (m *matcher) isEmpty() bool {
+	return len(m.exact) == 0 && len(m.patterns) == 0
+}
+
+// match reports whether any of the given names (e.g. short name and full
+// model ID) is matched.
+func (m *matcher) match(names ...string) bool {
+	for _, name := range names {
+		if _, ok := m.exact[name]; ok {
+			return true
+		}
+	}
+	for _, pattern := range m.patterns {
+		for _, name := range names {
+			if pattern.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compileGlob turns a wildcard pattern into an anchored regular expression.
+// '*' matches any sequence of characters (including '/'), '?' a single one.
+func compileGlob(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+func (f *ModelFilter) Entries() []string {
+	return f.entries
+}
+
+func (f *ModelFilter) IsEmpty() bool {
+	return len(f.entries) == 0
+}
+
+// Allows reports whether a model passes the filter. An empty allowlist allows
+// every model; a blocklist match always excludes it.
+func (f *ModelFilter) Allows(names ...string) bool {
+	if !f.allow.isEmpty() && !f.allow.match(names...) {
+		return false
+	}
+	return !f.block.match(names...)
+}
+
+func loadModelFilter(path string) (*ModelFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	var entries []string
+
+	for scanner.Scan() {
+		// everything after a # is a comment
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line != "" {
+			entries = append(entries, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewModelFilter(entries), nil
+}
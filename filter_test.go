@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeTestFile writes content to a file in a temporary directory and returns
+// its path.
+func writeTestFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadModelFilterComments(t *testing.T) {
+	path := writeTestFile(t, "models-filter", `# models for the team
+openai/gpt-4o
+
+  deepseek/deepseek-r1:free   # reasoning
+	
+#anthropic/claude-3.5-sonnet
+!*:free # no free models
+`)
+	filter, err := loadModelFilter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"openai/gpt-4o", "deepseek/deepseek-r1:free", "!*:free"}
+	if !slices.Equal(filter.Entries(), want) {
+		t.Errorf("entries = %q, want %q", filter.Entries(), want)
+	}
+	if !filter.Allows("openai/gpt-4o") || filter.Allows("anthropic/claude-3.5-sonnet") {
+		t.Error("commented out models must not be allowed")
+	}
+}
+
+func TestLoadModelFilterOnlyComments(t *testing.T) {
+	path := writeTestFile(t, "models-filter", "# nothing filtered yet\n\n   \n")
+	filter, err := loadModelFilter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.IsEmpty() || !filter.Allows("openai/gpt-4o") {
+		t.Errorf("entries = %q, want an empty filter allowing every model", filter.Entries())
+	}
+}
+
+// withTestFilter replaces the global models filter for the duration of a
+// test, and allows every model for embeddings.
+func withTestFilter(t *testing.T, entries ...string) {
+	t.Helper()
+	previous := modelFilter.Load()
+	modelFilter.Store(NewModelFilter(entries))
+	t.Cleanup(func() { modelFilter.Store(previous) })
+	withTestEmbeddingsFilter(t)
+}
+
+// withTestEmbeddingsFilter replaces the global embeddings filter for the
+// duration of a test.
+func withTestEmbeddingsFilter(t *testing.T, entries ...string) {
+	t.Helper()
+	previous := embeddingsFilter.Load()
+	embeddingsFilter.Store(NewModelFilter(entries))
+	t.Cleanup(func() { embeddingsFilter.Store(previous) })
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// defaultBaseUrl is the upstream used unless configured otherwise.
+const defaultBaseUrl = "https://openrouter.ai/api/v1/"
+
+// cliConfig holds the settings that can be given on the command line. Flags
+// take precedence over their environment variables.
+type cliConfig struct {
+	apiKey        string
+	baseUrl       string
+	listen        string
+	filter        string
+	skipPreflight bool
+}
+
+// parseFlags parses the command line. The positional arguments of earlier
+// versions ("<api-key>" or "<base-url> <api-key>") are still accepted, after
+// the environment variables, but are deprecated.
+func parseFlags(args []string) cliConfig {
+	fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+	apiKey := fs.String("api-key", "", "upstream API key (env OPENAI_API_KEY)")
+	baseUrl := fs.String("base-url", "", "upstream OpenAI-compatible API URL (env OPENAI_BASE_URL, default "+defaultBaseUrl+")")
+	listen := fs.String("listen", "", "address to listen on (env LISTEN_ADDR, default :11434)")
+	filter := fs.String("filter", "", "path of the models filter file (env MODELS_FILTER_PATH, default "+defaultModelFilterPath+")")
+	// read by envFileFlag before the flags are parsed
+	fs.String("env-file", defaultEnvFile, "file to load environment variables from, without overriding those already set")
+	skipPreflight := fs.Bool("skip-preflight", getEnvBool("SKIP_PREFLIGHT", false), "don't check the upstream at startup, e.g. to start offline (env SKIP_PREFLIGHT)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n\nFlags:\n", args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+
+	config := cliConfig{
+		apiKey:        firstNonEmpty(*apiKey, os.Getenv("OPENAI_API_KEY")),
+		baseUrl:       firstNonEmpty(*baseUrl, os.Getenv("OPENAI_BASE_URL")),
+		listen:        firstNonEmpty(*listen, os.Getenv("LISTEN_ADDR"), ":11434"),
+		filter:        firstNonEmpty(*filter, os.Getenv("MODELS_FILTER_PATH"), defaultModelFilterPath),
+		skipPreflight: *skipPreflight,
+	}
+
+	if positional := fs.Args(); len(positional) > 0 {
+		slog.Warn("Positional arguments are deprecated, use --api-key and --base-url instead")
+		if config.apiKey == "" {
+			config.apiKey = positional[len(positional)-1]
+		}
+		if config.baseUrl == "" && len(positional) > 1 {
+			config.baseUrl = positional[0]
+		}
+	}
+	if config.baseUrl == "" {
+		config.baseUrl = defaultBaseUrl
+	}
+	return config
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
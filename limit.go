@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// concurrencyLimit bounds the number of requests handled at once by the
+// handlers it is applied to. A request over the limit waits up to maxWait for
+// a slot before failing with 503. The slot is held until the handler returns,
+// i.e. for the whole duration of a stream. A limit of zero disables it.
+func concurrencyLimit(limit int, maxWait time.Duration) gin.HandlerFunc {
+	if limit <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	slots := make(chan struct{}, limit)
+	return func(c *gin.Context) {
+		select {
+		case slots <- struct{}{}:
+		default:
+			if !waitForSlot(c, slots, maxWait) {
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "too many concurrent requests"})
+				return
+			}
+		}
+		defer func() { <-slots }()
+		c.Next()
+	}
+}
+
+func waitForSlot(c *gin.Context, slots chan struct{}, maxWait time.Duration) bool {
+	if maxWait <= 0 {
+		return false
+	}
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
+
+// rateLimiter is an in-memory token bucket rate limiter with one bucket per
+// client.
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the client's bucket, or returns how long until one
+// is available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanup removes buckets that have refilled completely, which behave like
+// new ones.
+func (l *rateLimiter) cleanup(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimit allows each client requestsPerMinute requests, with bursts of up
+// to burst requests. Clients are identified by their proxy API key if auth is
+// enabled, else by IP. Requests over the limit fail with 429 and a
+// Retry-After header. Idle buckets are cleaned up until ctx is done. A rate
+// of zero disables the limit.
+func rateLimit(ctx context.Context, requestsPerMinute int, burst int) gin.HandlerFunc {
+	if requestsPerMinute <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	if burst <= 0 {
+		burst = requestsPerMinute
+	}
+
+	limiter := &rateLimiter{
+		rate:    float64(requestsPerMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				limiter.cleanup(now)
+			}
+		}
+	}()
+
+	return func(c *gin.Context) {
+		key := c.GetString(contextKeyAPIKey)
+		if key == "" {
+			key = c.ClientIP()
+		}
+
+		if ok, retryAfter := limiter.allow(key, time.Now()); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// defaultMaxBodyBytes bounds request bodies, generous enough for a few images.
+const defaultMaxBodyBytes = 20 << 20
+
+// bodyLimit rejects request bodies larger than maxBytes with 413. Bodies of
+// unknown length are cut off while reading, which handlers detect with
+// bodyTooLarge. A limit of zero disables it.
+func bodyLimit(maxBytes int64) gin.HandlerFunc {
+	if maxBytes <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBytes {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": bodyTooLargeMessage(maxBytes)})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
+// bodyTooLarge reports whether reading the request body failed because of
+// bodyLimit, returning the message to respond with.
+func bodyTooLarge(err error) (string, bool) {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return "", false
+	}
+	return bodyTooLargeMessage(maxBytesErr.Limit), true
+}
+
+func bodyTooLargeMessage(maxBytes int64) string {
+	return fmt.Sprintf("request body exceeds the limit of %d bytes", maxBytes)
+}
+
+// forwardRateLimitHeaders copies the rate limit headers of the upstream
+// response (X-RateLimit-* and Retry-After) to the response to the client, so
+// that clients can back off. For streams they are taken from the initial
+// response.
+func forwardRateLimitHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		ctx := withUpstreamHeaders(c.Request.Context(), func(upstream http.Header) {
+			for key, values := range upstream {
+				if strings.HasPrefix(key, "X-Ratelimit-") || key == "Retry-After" {
+					header[key] = values
+				}
+			}
+		})
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
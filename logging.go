@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gin context keys under which handlers store details of a request for use in
+// metrics and access logs.
+const (
+	contextKeyModel            = "model"
+	contextKeyPromptTokens     = "prompt_tokens"
+	contextKeyCompletionTokens = "completion_tokens"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+func withRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// contextHandler adds the request ID of the context passed to slog's *Context
+// functions to every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if requestID := requestIDFromContext(ctx); requestID != "" {
+		r.AddAttrs(slog.String("requestId", requestID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// setupLogger configures the default slog logger from LOG_LEVEL (debug, info,
+// warn, error) and LOG_FORMAT (text, json).
+func setupLogger() error {
+	var level slog.Level
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q", value)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// accessLog emits a single log line per request, including the resolved model
+// and token counts stored by the handlers. Each request is identified by the
+// incoming X-Request-ID header (or a generated ID), which is attached to the
+// request context and echoed in the response.
+func accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := c.GetHeader(requestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		c.Request = c.Request.WithContext(withRequestID(c.Request.Context(), requestID))
+		c.Header(requestIDHeader, requestID)
+
+		c.Next()
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"client", c.ClientIP(),
+		}
+		if model := c.GetString(contextKeyModel); model != "" {
+			attrs = append(attrs, "model", model)
+		}
+		if tokens, ok := c.Get(contextKeyPromptTokens); ok {
+			attrs = append(attrs, "promptTokens", tokens)
+		}
+		if tokens, ok := c.Get(contextKeyCompletionTokens); ok {
+			attrs = append(attrs, "completionTokens", tokens)
+		}
+		slog.InfoContext(c.Request.Context(), "Request", attrs...)
+	}
+}
@@ -1,312 +1,189 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
 	"errors"
-	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
-
-	"github.com/gin-gonic/gin"
-	openai "github.com/sashabaranov/go-openai"
 )
 
-var modelFilter map[string]struct{}
+// defaultOllamaVersion is the Ollama release reported by /api/version unless
+// overridden through OLLAMA_VERSION.
+const defaultOllamaVersion = "0.9.0"
 
-func loadModelFilter(path string) (map[string]struct{}, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	scanner := bufio.NewScanner(file)
-	filter := make(map[string]struct{})
+	// loaded first, as it may configure the logger and flag defaults
+	envFile, envFileGiven := envFileFlag(os.Args)
+	envLoaded, envErr := loadEnvFile(envFile, envFileGiven)
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line != "" {
-			filter[line] = struct{}{}
-		}
+	if err := setupLogger(); err != nil {
+		slog.Error("Error configuring logger", "Error", err)
+		return
 	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if envErr != nil {
+		slog.Error("Error loading env file", "path", envFile, "Error", envErr)
+		os.Exit(1)
 	}
-
-	return filter, nil
-}
-
-func main() {
-	r := gin.Default()
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		if len(os.Args) > 1 {
-			apiKey = os.Args[len(os.Args)-1]
-		} else {
-			slog.Error("OPENAI_API_KEY environment variable or command-line argument not set.")
-			return
-		}
+	if envLoaded > 0 {
+		slog.Info("Loaded environment from file", "path", envFile, "variables", envLoaded)
 	}
-
-	baseUrl := os.Getenv("OPENAI_BASE_URL")
-	if baseUrl == "" {
-		if len(os.Args) > 2 {
-			baseUrl = os.Args[1]
-		} else {
-			baseUrl = "https://openrouter.ai/api/v1/"
-		}
+	config := parseFlags(os.Args)
+	modelFilterPath = config.filter
+	embeddingsFilterPath = firstNonEmpty(os.Getenv("EMBEDDINGS_FILTER_PATH"), defaultEmbeddingsFilterPath)
+
+	settings := routerConfig{
+		corsOrigins:           parseCORSOrigins(os.Getenv("OLLAMA_CORS_ORIGINS")),
+		corsMethods:           firstNonEmpty(os.Getenv("OLLAMA_CORS_METHODS"), defaultCORSMethods),
+		corsHeaders:           firstNonEmpty(os.Getenv("OLLAMA_CORS_HEADERS"), defaultCORSHeaders),
+		compress:              getEnvBool("COMPRESS_RESPONSES", false),
+		logBodies:             getEnvBool("DEBUG_LOG_BODIES", false),
+		metrics:               getEnvBool("METRICS_ENABLED", true),
+		rateLimitRPM:          getEnvInt("RATE_LIMIT_RPM", 0),
+		rateLimitBurst:        getEnvInt("RATE_LIMIT_BURST", 0),
+		maxConcurrentRequests: getEnvInt("MAX_CONCURRENT_REQUESTS", 0),
+		maxQueueWait:          getEnvDuration("MAX_QUEUE_WAIT", 0),
+		maxBodyBytes:          int64(getEnvInt("MAX_BODY_BYTES", defaultMaxBodyBytes)),
+		defaultModel:          os.Getenv("OLLAMA_DEFAULT_MODEL"),
+		ollamaVersion:         firstNonEmpty(os.Getenv("OLLAMA_VERSION"), defaultOllamaVersion),
+		tagsMaxAge:            getEnvDuration("TAGS_MAX_AGE", time.Minute),
+		maxPromptChars:        getEnvInt("MAX_PROMPT_CHARS", 0),
+		maxPromptTokens:       getEnvInt("MAX_PROMPT_TOKENS", 0),
+		streamPrefix:          os.Getenv("STREAM_PREFIX"),
+		streamSuffix:          os.Getenv("STREAM_SUFFIX"),
+		// Clients that only read the terminal frame can opt into receiving
+		// the whole response there. Off by default, since clients
+		// concatenating the deltas would otherwise see the content twice.
+		streamFinalContent:   getEnvBool("STREAM_FINAL_CONTENT", false),
+		clientWriteTimeout:   getEnvDuration("CLIENT_WRITE_TIMEOUT", 0),
+		streamFlushFrames:    getEnvInt("STREAM_FLUSH_FRAMES", 1),
+		streamFlushInterval:  getEnvDuration("STREAM_FLUSH_INTERVAL", 0),
+		sseKeepAliveInterval: getEnvDuration("SSE_KEEPALIVE_INTERVAL", 15*time.Second),
 	}
-
-	provider := NewOpenrouterProvider(baseUrl, apiKey)
-
-	filter, err := loadModelFilter("models-filter")
+	clientKeys, err := parseClientKeys(os.Getenv("PROXY_KEY_MAP"))
 	if err != nil {
-		if os.IsNotExist(err) {
-			slog.Info("models-filter file not found. Skipping model filtering.")
-			modelFilter = make(map[string]struct{})
-		} else {
-			slog.Error("Error loading models filter", "Error", err)
-			return
-		}
-	} else {
-		modelFilter = filter
-		slog.Info("Loaded models from filter:")
-		for model := range modelFilter {
-			slog.Info(" - " + model)
-		}
+		slog.Error("Error parsing PROXY_KEY_MAP", "Error", err)
+		return
 	}
-
-	r.GET("/", func(c *gin.Context) {
-		c.String(http.StatusOK, "Ollama is running")
-	})
-	r.HEAD("/", func(c *gin.Context) {
-		c.String(http.StatusOK, "")
-	})
-
-	r.GET("/api/tags", func(c *gin.Context) {
-		models, err := provider.GetModels()
-		if err != nil {
-			slog.Error("Error getting models", "Error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		filter := modelFilter
-		newModels := make([]map[string]interface{}, 0, len(models))
-		for _, m := range models {
-			if len(filter) > 0 {
-				if _, ok := filter[m.Model]; !ok {
-					continue
-				}
-			}
-			newModels = append(newModels, map[string]interface{}{
-				"name":        m.Name,
-				"model":       m.Model,
-				"modified_at": m.ModifiedAt,
-				"size":        270898672,
-				"digest":      "9077fe9d2ae1a4a41a868836b56b8163731a8fe16621397028c2c76f838c6907",
-				"details":     m.Details,
-			})
-		}
-
-		c.JSON(http.StatusOK, gin.H{"models": newModels})
-	})
-
-	r.POST("/api/show", func(c *gin.Context) {
-		var request map[string]string
-		if err := c.BindJSON(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
-			return
-		}
-
-		modelName := request["name"]
-		if modelName == "" {
-			modelName = request["model"]
-		}
-		if modelName == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Model name is required"})
-			return
-		}
-
-		details, err := provider.GetModelDetails(modelName)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.JSON(http.StatusOK, details)
-	})
-
-	r.POST("/api/chat", func(c *gin.Context) {
-		var request struct {
-			Model    string                         `json:"model"`
-			Messages []openai.ChatCompletionMessage `json:"messages"`
-			Stream   *bool                          `json:"stream"`
-		}
-
-		if err := c.ShouldBindJSON(&request); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
-			return
-		}
-
-		streamRequested := true
-		if request.Stream != nil {
-			streamRequested = *request.Stream
-		}
-
-		if !streamRequested {
-			fullModelName, err := provider.GetFullModelName(request.Model)
-			if err != nil {
-				slog.Error("Error getting full model name", "Error", err)
-				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-				return
-			}
-
-			response, err := provider.Chat(request.Messages, fullModelName)
-			if err != nil {
-				slog.Error("Failed to get chat response", "Error", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-
-			if len(response.Choices) == 0 {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "No response from model"})
-				return
-			}
-
-			content := ""
-			if len(response.Choices) > 0 && response.Choices[0].Message.Content != "" {
-				content = response.Choices[0].Message.Content
-			}
-
-			finishReason := "stop"
-			if response.Choices[0].FinishReason != "" {
-				finishReason = string(response.Choices[0].FinishReason)
-			}
-
-			ollamaResponse := map[string]interface{}{
-				"model":      fullModelName,
-				"created_at": time.Now().Format(time.RFC3339),
-				"message": map[string]string{
-					"role":    "assistant",
-					"content": content,
-				},
-				"done":              true,
-				"finish_reason":     finishReason,
-				"total_duration":    response.Usage.TotalTokens * 10,
-				"load_duration":     0,
-				"prompt_eval_count": response.Usage.PromptTokens,
-				"eval_count":        response.Usage.CompletionTokens,
-				"eval_duration":     response.Usage.CompletionTokens * 10,
-			}
-
-			c.JSON(http.StatusOK, ollamaResponse)
-			return
-		}
-
-		slog.Info("Requested model", "model", request.Model)
-		fullModelName, err := provider.GetFullModelName(request.Model)
-		if err != nil {
-			slog.Error("Error getting full model name", "Error", err, "model", request.Model)
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
-			return
-		}
-		slog.Info("Using model", "fullModelName", fullModelName)
-
-		stream, err := provider.ChatStream(request.Messages, fullModelName)
+	settings.apiKeys = parseProxyAPIKeys(os.Getenv("PROXY_API_KEY"))
+	for key := range clientKeys {
+		settings.apiKeys = append(settings.apiKeys, key)
+	}
+	if settings.logBodies {
+		settings.redactPatterns, err = parseRedactPatterns(os.Getenv("DEBUG_LOG_REDACT_PATTERNS"))
 		if err != nil {
-			slog.Error("Failed to create stream", "Error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			slog.Error("Error parsing DEBUG_LOG_REDACT_PATTERNS", "Error", err)
 			return
 		}
-		defer stream.Close()
+	}
+	apiKey, baseUrl := config.apiKey, config.baseUrl
+	echoMode := getEnvBool("ECHO_MODE", false)
+	if apiKey == "" && !echoMode {
+		slog.Error("OPENAI_API_KEY environment variable or --api-key flag not set.")
+		return
+	}
 
-		c.Writer.Header().Set("Content-Type", "application/x-ndjson")
-		c.Writer.Header().Set("Cache-Control", "no-cache")
-		c.Writer.Header().Set("Connection", "keep-alive")
+	provider := NewOpenrouterProvider(baseUrl, apiKey)
+	if echoMode {
+		slog.Warn("ECHO_MODE is on, chat requests are answered with the last user message instead of the backend")
+	}
+	modelSource, err := newModelSource(os.Getenv("MODEL_SOURCE"), os.Getenv("MODELS_FILE"), provider)
+	if err != nil {
+		slog.Error("Error configuring MODEL_SOURCE", "Error", err)
+		return
+	}
+	provider.modelSource = modelSource
+	// the backend isn't called in echo mode, so there is nothing to check
+	if err := validateConfig(ctx, provider, !config.skipPreflight && !echoMode); err != nil {
+		slog.Error("Invalid configuration", "Error", err)
+		os.Exit(1)
+	}
 
-		w := c.Writer
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			slog.Error("Expected http.ResponseWriter to be an http.Flusher")
-			return
-		}
+	trimTrailingWhitespace = getEnvBool("TRIM_TRAILING_WHITESPACE", false)
+	nullToolCallContent = getEnvBool("NULL_TOOL_CALL_CONTENT", true)
+	defaultModelSize = int64(getEnvInt("DEFAULT_MODEL_SIZE", fallbackModelSize))
+	shutdownTimeout := getEnvDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
 
-		var lastFinishReason string
+	go reloadOnSIGHUP(ctx)
 
-		for {
-			response, err := stream.Recv()
-			if errors.Is(err, io.EOF) {
+	routes, err := parseProviderRoutes(os.Getenv("MODEL_ROUTES"), provider, baseUrl, apiKey)
+	if err != nil {
+		slog.Error("Error parsing MODEL_ROUTES", "Error", err)
+		return
+	}
+	routes.addClients(clientKeys, baseUrl)
+	if err := routes.addPrefixes(os.Getenv("UPSTREAM_PROVIDERS")); err != nil {
+		slog.Error("Error parsing UPSTREAM_PROVIDERS", "Error", err)
+		return
+	}
+	if sources := routes.modelSources(); len(sources) > 0 {
+		provider.modelSource = mergedModelSource{sources: append([]ModelSource{provider.modelSource}, sources...)}
+	}
 
-				break
-			}
-			if err != nil {
-				slog.Error("Backend stream error", "Error", err)
-				errorMsg := map[string]string{"error": "Stream error: " + err.Error()}
-				errorJson, _ := json.Marshal(errorMsg)
-				fmt.Fprintf(w, "%s\n", string(errorJson))
-				flusher.Flush()
-				return
-			}
+	contentPolicy, err = parseContentPolicy(os.Getenv("CONTENT_POLICY"))
+	if err != nil {
+		slog.Error("Error parsing CONTENT_POLICY", "Error", err)
+		return
+	}
 
-			if len(response.Choices) > 0 && response.Choices[0].FinishReason != "" {
-				lastFinishReason = string(response.Choices[0].FinishReason)
-			}
+	emptyResponsePlaceholder = os.Getenv("EMPTY_RESPONSE_PLACEHOLDER")
+	emptyResponsePolicy, err = parseEmptyResponsePolicy(os.Getenv("EMPTY_RESPONSE_POLICY"), emptyResponsePlaceholder)
+	if err != nil {
+		slog.Error("Error parsing EMPTY_RESPONSE_POLICY", "Error", err)
+		return
+	}
 
-			responseJSON := map[string]interface{}{
-				"model":      fullModelName,
-				"created_at": time.Now().Format(time.RFC3339),
-				"message": map[string]string{
-					"role":    "assistant",
-					"content": response.Choices[0].Delta.Content,
-				},
-				"done": false,
-			}
+	systemPrompt = os.Getenv("SYSTEM_PROMPT")
+	systemPromptMode, err = parseSystemPromptMode(os.Getenv("SYSTEM_PROMPT_MODE"))
+	if err != nil {
+		slog.Error("Error parsing SYSTEM_PROMPT_MODE", "Error", err)
+		return
+	}
 
-			jsonData, err := json.Marshal(responseJSON)
-			if err != nil {
-				slog.Error("Error marshaling intermediate response JSON", "Error", err)
-				return
-			}
+	defaultProviderRouting, err = parseProviderRouting(os.Getenv("OPENROUTER_PROVIDER"))
+	if err != nil {
+		slog.Error("Error parsing OPENROUTER_PROVIDER", "Error", err)
+		return
+	}
 
-			fmt.Fprintf(w, "%s\n", string(jsonData))
-			flusher.Flush()
-		}
+	stopSequences, err = parseStopSequences(os.Getenv("MODEL_STOP_SEQUENCES"))
+	if err != nil {
+		slog.Error("Error parsing MODEL_STOP_SEQUENCES", "Error", err)
+		return
+	}
 
-		if lastFinishReason == "" {
-			lastFinishReason = "stop"
-		}
+	settings.modelOrder, err = parseModelOrder(os.Getenv("TAGS_SORT"))
+	if err != nil {
+		slog.Error("Error parsing TAGS_SORT", "Error", err)
+		return
+	}
 
-		finalResponse := map[string]interface{}{
-			"model":      fullModelName,
-			"created_at": time.Now().Format(time.RFC3339),
-			"message": map[string]string{
-				"role":    "assistant",
-				"content": "",
-			},
-			"done":              true,
-			"finish_reason":     lastFinishReason,
-			"total_duration":    0,
-			"load_duration":     0,
-			"prompt_eval_count": 0,
-			"eval_count":        0,
-			"eval_duration":     0,
-		}
+	srv := &http.Server{
+		Addr:    config.listen,
+		Handler: newRouter(ctx, settings, provider, routes),
+	}
 
-		finalJsonData, err := json.Marshal(finalResponse)
-		if err != nil {
-			slog.Error("Error marshaling final response JSON", "Error", err)
-			return
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Server error", "Error", err)
+			stop()
 		}
+	}()
 
-		fmt.Fprintf(w, "%s\n", string(finalJsonData))
-		flusher.Flush()
-	})
+	<-ctx.Done()
+	stop()
 
-	r.Run(":11434")
+	slog.Info("Shutting down server", "gracePeriod", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Server did not shut down gracefully", "Error", err)
+	}
+	slog.Info("Server stopped")
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// nullToolCallContent controls whether assistant messages carrying only tool
+// calls are sent upstream with "content": null instead of an empty string,
+// which some providers reject.
+var nullToolCallContent = true
+
+func isToolCallOnly(message openai.ChatCompletionMessage) bool {
+	return message.Role == openai.ChatMessageRoleAssistant &&
+		len(message.ToolCalls) > 0 &&
+		message.Content == "" &&
+		len(message.MultiContent) == 0
+}
+
+// marshalNullToolCallContent returns the messages as JSON with a null content
+// for tool-call-only assistant messages, or nil if there are none (or the
+// conversion is disabled), in which case go-openai's encoding is used.
+func marshalNullToolCallContent(messages []openai.ChatCompletionMessage) []json.RawMessage {
+	if !nullToolCallContent {
+		return nil
+	}
+
+	found := false
+	for _, message := range messages {
+		if isToolCallOnly(message) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	encoded := make([]json.RawMessage, 0, len(messages))
+	for _, message := range messages {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return nil
+		}
+		if isToolCallOnly(message) {
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(data, &fields); err != nil {
+				return nil
+			}
+			fields["content"] = json.RawMessage("null")
+			if data, err = json.Marshal(fields); err != nil {
+				return nil
+			}
+		}
+		encoded = append(encoded, data)
+	}
+	return encoded
+}
+
+var chatRoles = []string{
+	openai.ChatMessageRoleSystem,
+	openai.ChatMessageRoleUser,
+	openai.ChatMessageRoleAssistant,
+	openai.ChatMessageRoleTool,
+}
+
+// validateChatRequest checks the messages of a chat request before they are
+// sent upstream, where malformed ones fail with less helpful errors: there
+// must be at least one, each with a known role and some content, except for
+// assistant messages carrying only tool calls.
+func validateChatRequest(messages []openai.ChatCompletionMessage) error {
+	if len(messages) == 0 {
+		return errors.New("messages must not be empty")
+	}
+	for i, message := range messages {
+		if !slices.Contains(chatRoles, message.Role) {
+			return fmt.Errorf("message %d has invalid role %q, expected system, user, assistant or tool", i, message.Role)
+		}
+		if message.Content == "" && len(message.MultiContent) == 0 && !isToolCallOnly(message) {
+			return fmt.Errorf("message %d has no content", i)
+		}
+	}
+	return nil
+}
+
+// Message is a chat message in Ollama's format, which attaches images as a
+// list of base64-encoded strings. Content may also be given as an array of
+// OpenAI content parts, which go-openai decodes into MultiContent and which is
+// sent to the backend unchanged.
+type Message struct {
+	openai.ChatCompletionMessage
+	Images []string `json:"images,omitempty"`
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	data, err := normalizeToolCalls(data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &m.ChatCompletionMessage); err != nil {
+		return err
+	}
+	var images struct {
+		Images []string `json:"images"`
+	}
+	if err := json.Unmarshal(data, &images); err != nil {
+		return err
+	}
+	m.Images = images.Images
+	return nil
+}
+
+// normalizeToolCalls rewrites the tool calls of a message in Ollama's format,
+// whose function arguments are a JSON object rather than a string and which
+// carry no type, into OpenAI's. Messages without such tool calls are returned
+// unchanged.
+func normalizeToolCalls(data []byte) ([]byte, error) {
+	var message map[string]json.RawMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, err
+	}
+	raw, ok := message["tool_calls"]
+	if !ok {
+		return data, nil
+	}
+	var toolCalls []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &toolCalls); err != nil {
+		// left to go-openai to report
+		return data, nil
+	}
+
+	changed := false
+	for _, toolCall := range toolCalls {
+		if _, ok := toolCall["type"]; !ok {
+			toolCall["type"] = json.RawMessage(`"function"`)
+			changed = true
+		}
+		var function map[string]json.RawMessage
+		if json.Unmarshal(toolCall["function"], &function) != nil {
+			continue
+		}
+		arguments := bytes.TrimSpace(function["arguments"])
+		if len(arguments) == 0 || arguments[0] == '"' || bytes.Equal(arguments, []byte("null")) {
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, arguments); err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(compact.String())
+		if err != nil {
+			return nil, err
+		}
+		function["arguments"] = encoded
+		if toolCall["function"], err = json.Marshal(function); err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+	if !changed {
+		return data, nil
+	}
+
+	var err error
+	if message["tool_calls"], err = json.Marshal(toolCalls); err != nil {
+		return nil, err
+	}
+	return json.Marshal(message)
+}
+
+// toChatMessages converts Ollama messages to OpenAI ones. Images are sent as
+// data URI image parts following the message's text.
+func toChatMessages(messages []Message) ([]openai.ChatCompletionMessage, error) {
+	converted := make([]openai.ChatCompletionMessage, 0, len(messages))
+	for i, message := range messages {
+		result := message.ChatCompletionMessage
+		if len(message.Images) > 0 {
+			parts := result.MultiContent
+			if result.Content != "" {
+				parts = append([]openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: result.Content}}, parts...)
+				result.Content = ""
+			}
+			for j, image := range message.Images {
+				data, err := base64.StdEncoding.DecodeString(image)
+				if err != nil {
+					return nil, fmt.Errorf("invalid base64 in image %d of message %d", j, i)
+				}
+				contentType := http.DetectContentType(data)
+				if !strings.HasPrefix(contentType, "image/") {
+					return nil, fmt.Errorf("image %d of message %d is not an image but %s", j, i, contentType)
+				}
+				parts = append(parts, openai.ChatMessagePart{
+					Type:     openai.ChatMessagePartTypeImageURL,
+					ImageURL: &openai.ChatMessageImageURL{URL: "data:" + contentType + ";base64," + image},
+				})
+			}
+			result.MultiContent = parts
+		}
+		converted = append(converted, result)
+	}
+	return converted, nil
+}
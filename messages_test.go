@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// writeTestCompletion answers a non-streamed chat completion request.
+func writeTestCompletion(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"id": "chatcmpl-test", "object": "chat.completion", "created": 1, "model": "openai/gpt-4o",
+		"choices": []map[string]any{{"index": 0, "message": map[string]any{"role": "assistant", "content": content}, "finish_reason": "stop"}},
+		"usage":   map[string]any{"prompt_tokens": 5, "completion_tokens": 3, "total_tokens": 8},
+	})
+}
+
+// Ollama clients replay tool calls with the arguments as an object and
+// without a type.
+func TestMessageOllamaToolCall(t *testing.T) {
+	var message Message
+	data := `{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city": "Berlin","days":2}}}]}`
+	if err := json.Unmarshal([]byte(data), &message); err != nil {
+		t.Fatal(err)
+	}
+	if len(message.ToolCalls) != 1 {
+		t.Fatalf("got %d tool calls, want 1", len(message.ToolCalls))
+	}
+	call := message.ToolCalls[0]
+	if call.Type != openai.ToolTypeFunction {
+		t.Errorf("type = %q, want function", call.Type)
+	}
+	if call.Function.Name != "get_weather" || call.Function.Arguments != `{"city":"Berlin","days":2}` {
+		t.Errorf("function = %+v", call.Function)
+	}
+}
+
+func TestMessageOpenAIToolCall(t *testing.T) {
+	var message Message
+	data := `{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"f","arguments":"{\"a\":1}"}}]}`
+	if err := json.Unmarshal([]byte(data), &message); err != nil {
+		t.Fatal(err)
+	}
+	call := message.ToolCalls[0]
+	if call.ID != "call_1" || call.Function.Arguments != `{"a":1}` {
+		t.Errorf("tool call = %+v", call)
+	}
+}
+
+// Tool-call-only assistant messages are sent upstream with a null content.
+func TestNullToolCallContentUpstream(t *testing.T) {
+	var sent struct {
+		Messages []map[string]json.RawMessage `json:"messages"`
+	}
+	provider, _ := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &sent); err != nil {
+			t.Errorf("decoding upstream body: %v", err)
+		}
+		writeTestCompletion(w, "It is sunny.")
+	})
+
+	var request struct {
+		Messages []Message `json:"messages"`
+	}
+	data := `{"messages":[
+		{"role":"user","content":"Weather in Berlin?"},
+		{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Berlin"}}}]},
+		{"role":"tool","content":"sunny"}
+	]}`
+	if err := json.Unmarshal([]byte(data), &request); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := toChatMessages(request.Messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Chat(context.Background(), buildChatRequest("openai/gpt-4o", messages, Options{}, UpstreamAPIChat)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sent.Messages) != 3 {
+		t.Fatalf("upstream got %d messages, want 3", len(sent.Messages))
+	}
+	assistant := sent.Messages[1]
+	if content, ok := assistant["content"]; !ok || string(content) != "null" {
+		t.Errorf("assistant content = %s, want null", content)
+	}
+	var toolCalls []openai.ToolCall
+	if err := json.Unmarshal(assistant["tool_calls"], &toolCalls); err != nil {
+		t.Fatal(err)
+	}
+	if len(toolCalls) != 1 || toolCalls[0].Function.Arguments != `{"city":"Berlin"}` || toolCalls[0].Type != openai.ToolTypeFunction {
+		t.Errorf("upstream tool calls = %+v", toolCalls)
+	}
+	if content := string(sent.Messages[0]["content"]); content != `"Weather in Berlin?"` {
+		t.Errorf("user content = %s", content)
+	}
+}
+
+var (
+	testPNG  = base64.StdEncoding.EncodeToString([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"))
+	testJPEG = base64.StdEncoding.EncodeToString([]byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00"))
+)
+
+func TestToChatMessagesImages(t *testing.T) {
+	messages, err := toChatMessages([]Message{{
+		ChatCompletionMessage: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "What is in these?"},
+		Images:                []string{testPNG, testJPEG},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := messages[0].MultiContent
+	if messages[0].Content != "" || len(parts) != 3 {
+		t.Fatalf("message = %+v, want the text and two image parts", messages[0])
+	}
+	if parts[0].Type != openai.ChatMessagePartTypeText || parts[0].Text != "What is in these?" {
+		t.Errorf("part 0 = %+v, want the text", parts[0])
+	}
+	for i, want := range []string{"data:image/png;base64," + testPNG, "data:image/jpeg;base64," + testJPEG} {
+		part := parts[i+1]
+		if part.Type != openai.ChatMessagePartTypeImageURL || part.ImageURL == nil || part.ImageURL.URL != want {
+			t.Errorf("part %d = %+v, want image %s", i+1, part, want)
+		}
+	}
+}
+
+func TestToChatMessagesInvalidImages(t *testing.T) {
+	tests := map[string]string{
+		"invalid base64": "not base64!",
+		"not an image":   base64.StdEncoding.EncodeToString([]byte("just some text")),
+	}
+	for name, image := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := toChatMessages([]Message{{
+				ChatCompletionMessage: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "hi"},
+				Images:                []string{testPNG, image},
+			}})
+			if err == nil || !strings.Contains(err.Error(), "image 1 of message 0") {
+				t.Errorf("err = %v, want an error for image 1 of message 0", err)
+			}
+		})
+	}
+}
+
+// Content given as OpenAI content parts reaches the backend unchanged.
+func TestMessageContentParts(t *testing.T) {
+	const parts = `[{"type":"text","text":"What is this?"},{"type":"image_url","image_url":{"url":"https://example.com/cat.png","detail":"low"}}]`
+	var sent struct {
+		Messages []map[string]json.RawMessage `json:"messages"`
+	}
+	provider, _ := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+			t.Errorf("decoding upstream body: %v", err)
+		}
+		writeTestCompletion(w, "A cat.")
+	})
+
+	var request struct {
+		Messages []Message `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(`{"messages":[{"role":"user","content":`+parts+`},{"role":"assistant","content":"A cat."},{"role":"user","content":"Sure?"}]}`), &request); err != nil {
+		t.Fatal(err)
+	}
+	first := request.Messages[0]
+	if first.Content != "" || len(first.MultiContent) != 2 || first.MultiContent[0].Text != "What is this?" || first.MultiContent[1].ImageURL == nil {
+		t.Fatalf("message = %+v, want the text and image_url parts", first)
+	}
+	if request.Messages[1].Content != "A cat." || request.Messages[1].MultiContent != nil {
+		t.Errorf("string content = %+v, want it kept as Content", request.Messages[1])
+	}
+
+	messages, err := toChatMessages(request.Messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Chat(context.Background(), buildChatRequest("openai/gpt-4o", messages, Options{}, UpstreamAPIChat)); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent.Messages) != 3 {
+		t.Fatalf("upstream got %d messages, want 3", len(sent.Messages))
+	}
+	if content := string(sent.Messages[0]["content"]); content != parts {
+		t.Errorf("upstream content = %s, want %s", content, parts)
+	}
+	if content := string(sent.Messages[2]["content"]); content != `"Sure?"` {
+		t.Errorf("upstream content = %s, want the string", content)
+	}
+}
+
+// Images attached Ollama-style follow the parts of a message.
+func TestToChatMessagesPartsAndImages(t *testing.T) {
+	var message Message
+	if err := json.Unmarshal([]byte(`{"role":"user","content":[{"type":"text","text":"Compare"}],"images":["`+testPNG+`"]}`), &message); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := toChatMessages([]Message{message})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := messages[0].MultiContent
+	if len(parts) != 2 || parts[0].Text != "Compare" || parts[1].ImageURL == nil || parts[1].ImageURL.URL != "data:image/png;base64,"+testPNG {
+		t.Errorf("parts = %+v, want the text followed by the image", parts)
+	}
+}
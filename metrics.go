@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ollama_proxy_requests_total",
+		Help: "Number of handled requests.",
+	}, []string{"endpoint", "model", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ollama_proxy_request_duration_seconds",
+		Help:    "Latency of handled requests, including the full duration of streams.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"endpoint", "model"})
+
+	streamedTokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ollama_proxy_streamed_tokens_total",
+		Help: "Number of content chunks streamed to clients.",
+	}, []string{"endpoint", "model"})
+
+	upstreamErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ollama_proxy_upstream_errors_total",
+		Help: "Number of failed calls to the upstream API.",
+	}, []string{"operation", "model"})
+)
+
+// listedModels holds the full IDs of every model listed by the upstream. Only
+// these are used as model labels, as clients can request any model name,
+// which would grow the metrics without bound.
+var listedModels sync.Map
+
+// modelLabel returns the model label of a metric: the model if it was
+// listed, or "unknown".
+func modelLabel(model string) string {
+	if model == "" {
+		return ""
+	}
+	if _, ok := listedModels.Load(model); !ok {
+		return "unknown"
+	}
+	return model
+}
+
+// registerMetrics instruments all subsequently registered routes and exposes
+// the collected metrics at /metrics.
+func registerMetrics(r *gin.Engine) {
+	prometheus.MustRegister(requestsTotal, requestDuration, streamedTokensTotal, upstreamErrorsTotal)
+
+	r.Use(func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		endpoint := c.FullPath()
+		if endpoint == "" {
+			endpoint = "unmatched"
+		}
+		model := modelLabel(c.GetString(contextKeyModel))
+		requestsTotal.WithLabelValues(endpoint, model, strconv.Itoa(c.Writer.Status())).Inc()
+		requestDuration.WithLabelValues(endpoint, model).Observe(time.Since(start).Seconds())
+	})
+
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestModelLabel(t *testing.T) {
+	provider, _ := newTestProvider(t, nil)
+	if _, err := provider.GetModels(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		model, want string
+	}{
+		{"openai/gpt-4o", "openai/gpt-4o"},
+		{"client/made-up-model-1234", "unknown"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := modelLabel(tt.model); got != tt.want {
+			t.Errorf("modelLabel(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+}
+
+// Upstream errors for models that were never listed don't add label values.
+func TestUpstreamErrorsUnknownModel(t *testing.T) {
+	provider, _ := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"no such model"}}`, http.StatusNotFound)
+	})
+	unknown := upstreamErrorsTotal.WithLabelValues("chat", "unknown")
+	before := testutil.ToFloat64(unknown)
+
+	req := buildChatRequest("client/made-up-model-5678", nil, Options{}, UpstreamAPIChat)
+	if _, err := provider.Chat(context.Background(), req); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := testutil.ToFloat64(unknown) - before; got != 1 {
+		t.Errorf("unknown model errors increased by %v, want 1", got)
+	}
+}
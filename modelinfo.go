@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// fallbackModelSize is the default of DEFAULT_MODEL_SIZE.
+const fallbackModelSize = 270898672
+
+// defaultModelSize is the size reported for models whose parameter count
+// can't be derived from their ID.
+var defaultModelSize int64 = fallbackModelSize
+
+// modelDigest returns a stable digest for a model, derived from its full ID
+// since remote models have no weights to hash.
+func modelDigest(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// parameterCountPattern matches parameter counts in model IDs, e.g. "70b" in
+// "llama-3-70b-instruct", "8x7b" in "mixtral-8x7b" or "1.5B" in "qwen-1.5B".
+var parameterCountPattern = regexp.MustCompile(`(?i)(?:^|[-_/:.])(?:(\d+)x)?(\d+(?:\.\d+)?)([bm])(?:$|[-_/:.])`)
+
+// bitsPerWeight approximates the storage cost of common quantization levels.
+var bitsPerWeight = map[string]float64{
+	"Q4_0":   4.5,
+	"Q4_K_M": 4.85,
+	"Q5_K_M": 5.7,
+	"Q6_K":   6.6,
+	"Q8_0":   8.5,
+	"F16":    16,
+}
+
+// parseParameterCount derives the number of parameters from a model ID.
+func parseParameterCount(id string) (float64, bool) {
+	match := parameterCountPattern.FindStringSubmatch(id)
+	if match == nil {
+		return 0, false
+	}
+
+	count, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return 0, false
+	}
+	if match[1] != "" {
+		experts, _ := strconv.Atoi(match[1])
+		count *= float64(experts)
+	}
+	if strings.EqualFold(match[3], "b") {
+		count *= 1e9
+	} else {
+		count *= 1e6
+	}
+	return count, true
+}
+
+// formatParameterSize formats a parameter count the way Ollama does, e.g. "70B".
+func formatParameterSize(count float64) string {
+	if count >= 1e9 {
+		return strconv.FormatFloat(math.Round(count/1e8)/10, 'f', -1, 64) + "B"
+	}
+	return strconv.FormatFloat(math.Round(count/1e5)/10, 'f', -1, 64) + "M"
+}
+
+// estimateModelSize returns a plausible size in bytes for a model with the given
+// parameter count and quantization level.
+func estimateModelSize(count float64, quantization string) int64 {
+	bits, ok := bitsPerWeight[quantization]
+	if !ok {
+		bits = 16
+	}
+	return int64(count * bits / 8)
+}
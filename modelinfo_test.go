@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestParseParameterCount(t *testing.T) {
+	tests := []struct {
+		id   string
+		want float64
+		ok   bool
+	}{
+		{"meta-llama/llama-3-70b-instruct", 70e9, true},
+		{"mistralai/mixtral-8x7b-instruct", 56e9, true},
+		{"qwen/qwen-2.5-1.5B", 1.5e9, true},
+		{"microsoft/phi-3-mini-128k-instruct", 0, false},
+		{"openai/gpt-4o", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseParameterCount(tt.id)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseParameterCount(%q) = %v, %v, want %v, %v", tt.id, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// Models of differing parameter counts are listed with distinct sizes, and
+// those without one with the default size.
+func TestNewModelSize(t *testing.T) {
+	small := newModel("meta-llama/llama-3-8b-instruct", "")
+	large := newModel("meta-llama/llama-3-70b-instruct", "")
+	if small.Size >= large.Size {
+		t.Errorf("sizes = %d (8B), %d (70B), want the larger model to be larger", small.Size, large.Size)
+	}
+	if want := estimateModelSize(70e9, "Q4_K_M"); large.Size != want || large.Details.ParameterSize != "70B" {
+		t.Errorf("70B model = %d, %s, want %d, 70B", large.Size, large.Details.ParameterSize, want)
+	}
+
+	previous := defaultModelSize
+	defaultModelSize = 12345
+	t.Cleanup(func() { defaultModelSize = previous })
+	if unknown := newModel("openai/gpt-4o", ""); unknown.Size != 12345 {
+		t.Errorf("size = %d, want the default 12345", unknown.Size)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ModelSource provides the catalog of models listed by the proxy.
+type ModelSource interface {
+	ListModels(ctx context.Context) ([]upstreamModel, error)
+}
+
+// upstreamModelSource lists the models of the upstream API.
+type upstreamModelSource struct {
+	provider *OpenrouterProvider
+}
+
+func (s upstreamModelSource) ListModels(ctx context.Context) ([]upstreamModel, error) {
+	return s.provider.listModels(ctx)
+}
+
+// staticModelSource lists a fixed set of models, e.g. for offline use or a
+// curated catalog.
+type staticModelSource struct {
+	models []upstreamModel
+}
+
+func (s staticModelSource) ListModels(ctx context.Context) ([]upstreamModel, error) {
+	return s.models, nil
+}
+
+// loadStaticModels reads a JSON array of models in the format of the upstream
+// model list, e.g. [{"id": "openai/gpt-4o", "context_length": 128000}].
+func loadStaticModels(path string) (staticModelSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return staticModelSource{}, err
+	}
+	var models []upstreamModel
+	if err := json.Unmarshal(data, &models); err != nil {
+		return staticModelSource{}, fmt.Errorf("invalid models file %s: %w", path, err)
+	}
+	for i, model := range models {
+		if model.ID == "" {
+			return staticModelSource{}, fmt.Errorf("invalid models file %s: model %d has no id", path, i)
+		}
+	}
+	return staticModelSource{models: models}, nil
+}
+
+// mergedModelSource combines the models of several sources. A model listed by
+// more than one source is taken from the first.
+type mergedModelSource struct {
+	sources []ModelSource
+}
+
+func (s mergedModelSource) ListModels(ctx context.Context) ([]upstreamModel, error) {
+	seen := make(map[string]struct{})
+	var merged []upstreamModel
+	for _, source := range s.sources {
+		models, err := source.ListModels(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, model := range models {
+			if _, ok := seen[model.ID]; ok {
+				continue
+			}
+			seen[model.ID] = struct{}{}
+			merged = append(merged, model)
+		}
+	}
+	return merged, nil
+}
+
+// newModelSource builds the source selected by MODEL_SOURCE: "upstream"
+// (default), "static" or "merged", where the latter two read modelsFile and
+// merged lists its models ahead of the upstream ones.
+func newModelSource(value string, modelsFile string, provider *OpenrouterProvider) (ModelSource, error) {
+	upstream := upstreamModelSource{provider: provider}
+	switch value {
+	case "", "upstream":
+		return upstream, nil
+	case "static", "merged":
+		if modelsFile == "" {
+			return nil, errors.New(value + " model source requires MODELS_FILE")
+		}
+		static, err := loadStaticModels(modelsFile)
+		if err != nil {
+			return nil, err
+		}
+		if value == "static" {
+			return static, nil
+		}
+		return mergedModelSource{sources: []ModelSource{static, upstream}}, nil
+	default:
+		return nil, fmt.Errorf("unknown model source %q", value)
+	}
+}
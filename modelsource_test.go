@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// newTestSourceRouter serves the proxy API with the models of MODEL_SOURCE
+// source, reading models from a file of the given content.
+func newTestSourceRouter(t *testing.T, source string, models string) (http.Handler, *OpenrouterProvider) {
+	t.Helper()
+	withTestFilter(t)
+	provider, _ := newTestProvider(t, nil)
+	modelSource, err := newModelSource(source, writeTestFile(t, "models.json", models), provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.modelSource = modelSource
+	return newRouter(context.Background(), routerConfig{modelOrder: ModelOrderName}, provider, &ProviderRoutes{fallback: provider}), provider
+}
+
+func tagsModelNames(t *testing.T, router http.Handler) []string {
+	t.Helper()
+	var names []string
+	for _, m := range tagsModels(t, router) {
+		names = append(names, m["model"].(string))
+	}
+	return names
+}
+
+func TestStaticModelSource(t *testing.T) {
+	router, provider := newTestSourceRouter(t, "static", `[{"id": "local/llama-3-8b", "context_length": 8192}, {"id": "local/phi3"}]`)
+
+	if got, want := tagsModelNames(t, router), []string{"llama-3-8b", "phi3"}; !slices.Equal(got, want) {
+		t.Errorf("tags = %q, want %q", got, want)
+	}
+	if info, ok := provider.modelInfoFor("local/llama-3-8b"); !ok || info.ContextLength != 8192 {
+		t.Errorf("metadata = %+v, %v, want the context length of the file", info, ok)
+	}
+}
+
+// Merged sources list the file's models ahead of the upstream ones, which
+// don't replace models of the same ID.
+func TestMergedModelSource(t *testing.T) {
+	router, _ := newTestSourceRouter(t, "merged", `[{"id": "local/phi3"}, {"id": "openai/gpt-4o", "context_length": 1}]`)
+
+	want := []string{"claude-3.5-sonnet", "deepseek-r1:free", "gpt-4o", "phi3"}
+	if got := tagsModelNames(t, router); !slices.Equal(got, want) {
+		t.Errorf("tags = %q, want %q", got, want)
+	}
+
+	source := mergedModelSource{sources: []ModelSource{
+		staticModelSource{models: []upstreamModel{{Model: openai.Model{ID: "openai/gpt-4o"}, ContextLength: 1}}},
+		staticModelSource{models: []upstreamModel{{Model: openai.Model{ID: "openai/gpt-4o"}, ContextLength: 128000}, {Model: openai.Model{ID: "local/phi3"}}}},
+	}}
+	models, err := source.ListModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != 2 || models[0].ID != "openai/gpt-4o" || models[0].ContextLength != 1 || models[1].ID != "local/phi3" {
+		t.Errorf("merged = %+v, want gpt-4o of the first source, then phi3", models)
+	}
+}
+
+func TestNewModelSourceErrors(t *testing.T) {
+	provider, _ := newTestProvider(t, nil)
+	for _, tt := range []struct{ source, file string }{
+		{"static", ""},
+		{"merged", ""},
+		{"static", writeTestFile(t, "models.json", `[{"context_length": 1}]`)},
+		{"static", writeTestFile(t, "models.json", `{}`)},
+		{"catalog", ""},
+	} {
+		if _, err := newModelSource(tt.source, tt.file, provider); err == nil {
+			t.Errorf("newModelSource(%q, %q) succeeded, want an error", tt.source, tt.file)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// openAIError is the error body of the OpenAI-compatible /v1 endpoints, whose
+// clients expect an error object rather than Ollama's error string.
+func openAIError(status int, message string) gin.H {
+	errorType := "invalid_request_error"
+	if status >= http.StatusInternalServerError {
+		errorType = "server_error"
+	}
+	return gin.H{"error": gin.H{"message": message, "type": errorType}}
+}
+
+// legacyPrompt reads the prompt of a legacy completion request, which is a
+// string or an array holding a single string.
+func legacyPrompt(raw json.RawMessage) (string, error) {
+	var prompt string
+	if err := json.Unmarshal(raw, &prompt); err == nil {
+		return prompt, nil
+	}
+	var prompts []string
+	if err := json.Unmarshal(raw, &prompts); err != nil || len(prompts) != 1 {
+		return "", errors.New("prompt must be a string or an array of one string")
+	}
+	return prompts[0], nil
+}
+
+// embeddingInputs reads the input of an embeddings request, a string or an
+// array of strings.
+func embeddingInputs(raw json.RawMessage) ([]string, error) {
+	var input string
+	if err := json.Unmarshal(raw, &input); err == nil {
+		return []string{input}, nil
+	}
+	var inputs []string
+	if err := json.Unmarshal(raw, &inputs); err != nil || len(inputs) == 0 {
+		return nil, errors.New("input must be a string or a non-empty array of strings")
+	}
+	return inputs, nil
+}
+
+// encodeEmbedding encodes a vector for the "base64" encoding_format: its
+// float32 values in little-endian byte order, base64 encoded.
+func encodeEmbedding(vector []float32) string {
+	data := make([]byte, 0, 4*len(vector))
+	for _, value := range vector {
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(value))
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// legacyCompletion is a response (or streamed chunk) of the legacy
+// completions API. go-openai's type can't leave out logprobs and usage, or
+// send a null finish reason for unfinished chunks.
+type legacyCompletion struct {
+	ID      string                   `json:"id"`
+	Object  string                   `json:"object"`
+	Created int64                    `json:"created"`
+	Model   string                   `json:"model"`
+	Choices []legacyCompletionChoice `json:"choices"`
+	Usage   *openai.Usage            `json:"usage,omitempty"`
+}
+
+type legacyCompletionChoice struct {
+	Text         string  `json:"text"`
+	Index        int     `json:"index"`
+	Logprobs     any     `json:"logprobs"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+func newLegacyChoice(index int, text string, finishReason openai.FinishReason) legacyCompletionChoice {
+	choice := legacyCompletionChoice{Text: text, Index: index}
+	if finishReason != "" {
+		reason := string(finishReason)
+		choice.FinishReason = &reason
+	}
+	return choice
+}
+
+// toLegacyCompletion converts a chat completion into the response of the
+// legacy completions API.
+func toLegacyCompletion(resp openai.ChatCompletionResponse, model string) legacyCompletion {
+	choices := make([]legacyCompletionChoice, 0, len(resp.Choices))
+	for _, choice := range resp.Choices {
+		choices = append(choices, newLegacyChoice(choice.Index, choice.Message.Content, choice.FinishReason))
+	}
+	return legacyCompletion{
+		ID:      resp.ID,
+		Object:  "text_completion",
+		Created: resp.Created,
+		Model:   model,
+		Choices: choices,
+		Usage:   &resp.Usage,
+	}
+}
+
+// writeSSE writes a server-sent event with the JSON encoding of v as its data.
+func writeSSE(w *ndjsonWriter, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.WriteLine(append(append([]byte("data: "), data...), '\n'))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// decodeTestEmbedding decodes a vector like a client of the "base64"
+// encoding_format.
+func decodeTestEmbedding(t *testing.T, encoded string) []float32 {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data)%4 != 0 {
+		t.Fatalf("got %d bytes, not a multiple of 4", len(data))
+	}
+	vector := make([]float32, 0, len(data)/4)
+	for i := 0; i < len(data); i += 4 {
+		vector = append(vector, math.Float32frombits(binary.LittleEndian.Uint32(data[i:])))
+	}
+	return vector
+}
+
+func TestEncodeEmbeddingRoundTrip(t *testing.T) {
+	vectors := [][]float32{
+		{},
+		{0.5},
+		{0.0123, -1.5, 3.4028235e38, -0, float32(math.SmallestNonzeroFloat32)},
+	}
+	for _, vector := range vectors {
+		if got := decodeTestEmbedding(t, encodeEmbedding(vector)); !slices.Equal(got, vector) {
+			t.Errorf("decoded %v, want %v", got, vector)
+		}
+	}
+}
+
+func TestEmbeddingsBase64(t *testing.T) {
+	vector := []float32{0.25, -0.125, 1e-7}
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"object": "list", "model": "openai/text-embedding-3-small",
+			"data":  []map[string]any{{"object": "embedding", "embedding": vector, "index": 0}},
+			"usage": map[string]any{"prompt_tokens": 2, "total_tokens": 2},
+		})
+	})
+
+	recorder := serveTestRequest(router, http.MethodPost, "/v1/embeddings", `{"model":"openai/text-embedding-3-small","input":"hello","encoding_format":"base64"}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	var response struct {
+		Data []struct {
+			Embedding string `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Data) != 1 {
+		t.Fatalf("got %d embeddings, want 1", len(response.Data))
+	}
+	if got := decodeTestEmbedding(t, response.Data[0].Embedding); !slices.Equal(got, vector) {
+		t.Errorf("decoded %v, want %v", got, vector)
+	}
+}
+
+// The embeddings-filter restricts the models of embeddings requests only.
+func TestEmbeddingsFilter(t *testing.T) {
+	var calls int
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"object": "list", "model": "openai/text-embedding-3-small",
+			"data":  []map[string]any{{"object": "embedding", "embedding": []float32{0.5}, "index": 0}},
+			"usage": map[string]any{"prompt_tokens": 1, "total_tokens": 1},
+		})
+	})
+	withTestEmbeddingsFilter(t, "*embed*")
+
+	tests := []struct {
+		model      string
+		wantStatus int
+	}{
+		{"openai/text-embedding-3-small", http.StatusOK},
+		{"text-embedding-3-small", http.StatusOK},
+		{"gpt-4o", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		calls = 0
+		recorder := serveTestRequest(router, http.MethodPost, "/v1/embeddings", `{"model":"`+tt.model+`","input":"hello"}`)
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d, body %s", tt.model, recorder.Code, tt.wantStatus, recorder.Body)
+		}
+		if wantCalls := map[bool]int{true: 1, false: 0}[tt.wantStatus == http.StatusOK]; calls != wantCalls {
+			t.Errorf("%s: backend called %d times, want %d", tt.model, calls, wantCalls)
+		}
+	}
+	// the chat models listed aren't affected
+	if models := tagsModels(t, router); len(models) != len(testModels) {
+		t.Errorf("tags lists %d models, want all %d", len(models), len(testModels))
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// defaultProviderRouting is the OpenRouter provider routing object (e.g.
+// {"order": ["openai"], "allow_fallbacks": false}) sent with every chat request
+// that doesn't set options.provider itself.
+var defaultProviderRouting json.RawMessage
+
+// Options holds the subset of Ollama's request options understood by the proxy.
+type Options struct {
+	Stop     []string        `json:"stop,omitempty"`
+	Provider json.RawMessage `json:"provider,omitempty"`
+	API      string          `json:"api,omitempty"`
+	// NumCtx is not forwarded, since the backend manages the context window
+	// itself; values beyond the model's context length are logged.
+	NumCtx    int            `json:"num_ctx,omitempty"`
+	LogitBias map[string]int `json:"logit_bias,omitempty"`
+	User      string         `json:"user,omitempty"`
+	// ReasoningEffort is "low", "medium" or "high" for reasoning models.
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	// NumPredict caps the generated tokens; -1 and -2 (unlimited, fill the
+	// context) leave it to the backend.
+	NumPredict int `json:"num_predict,omitempty"`
+	// Think is the request's top-level think flag.
+	Think *bool `json:"-"`
+	// openAIReasoning is set for OpenAI reasoning models without metadata,
+	// which take reasoning_effort rather than OpenRouter's reasoning object.
+	openAIReasoning bool
+}
+
+func validateReasoningEffort(effort string) error {
+	switch effort {
+	case "", "low", "medium", "high":
+		return nil
+	default:
+		return fmt.Errorf("reasoning_effort must be low, medium or high, not %q", effort)
+	}
+}
+
+// forModel drops options the model doesn't support: think and
+// reasoning_effort are only forwarded to models listing the reasoning
+// parameter. Without a parameter list, reasoning_effort is still forwarded to
+// OpenAI's reasoning models, told apart by name.
+func (o Options) forModel(provider *OpenrouterProvider, fullModelName string) Options {
+	if provider.modelSupportsReasoning(fullModelName) {
+		return o
+	}
+	o.Think = nil
+	if provider.modelListsParameters(fullModelName) || !usesMaxCompletionTokens(fullModelName) {
+		o.ReasoningEffort = ""
+	}
+	o.openAIReasoning = o.ReasoningEffort != ""
+	return o
+}
+
+// validateLogitBias checks that logit_bias maps token IDs to biases within
+// the range accepted by OpenAI.
+func validateLogitBias(bias map[string]int) error {
+	for token, value := range bias {
+		if _, err := strconv.ParseUint(token, 10, 32); err != nil {
+			return fmt.Errorf("logit_bias key %q is not a token ID", token)
+		}
+		if value < -100 || value > 100 {
+			return fmt.Errorf("logit_bias value %d for token %s is outside [-100, 100]", value, token)
+		}
+	}
+	return nil
+}
+
+// buildChatRequest maps an Ollama chat request onto an OpenAI chat completion
+// request for the given (resolved) model and upstream API.
+func buildChatRequest(modelName string, messages []openai.ChatCompletionMessage, options Options, api UpstreamAPI) ChatRequest {
+	req := ChatRequest{
+		ChatCompletionRequest: openai.ChatCompletionRequest{
+			Model:    modelName,
+			Messages: messages,
+			Stop:     stopSequences.Merge(modelName, options.Stop),
+			// nil unless given, so it is omitted upstream
+			LogitBias: options.LogitBias,
+			User:      options.User,
+		},
+		Extra: make(map[string]any),
+		API:   api,
+	}
+
+	if options.NumPredict > 0 {
+		if usesMaxCompletionTokens(modelName) {
+			req.MaxCompletionTokens = options.NumPredict
+		} else {
+			req.MaxTokens = options.NumPredict
+		}
+	}
+	if api == UpstreamAPIChat {
+		if encoded := marshalNullToolCallContent(messages); encoded != nil {
+			req.Extra["messages"] = encoded
+		}
+	}
+	// OpenRouter's unified reasoning parameter, which also carries the
+	// effort; without thinking, reasoning is kept to a minimum and left out
+	// of the response whatever effort was asked for. OpenAI's reasoning
+	// models without metadata only get reasoning_effort.
+	switch {
+	case options.openAIReasoning:
+		req.Extra["reasoning_effort"] = options.ReasoningEffort
+	case options.Think != nil && !*options.Think:
+		req.Extra["reasoning"] = map[string]any{"effort": "low", "exclude": true}
+	case options.ReasoningEffort != "":
+		req.Extra["reasoning"] = map[string]any{"effort": options.ReasoningEffort}
+	case options.Think != nil:
+		req.Extra["reasoning"] = map[string]any{"enabled": true}
+	}
+	if len(options.Provider) > 0 {
+		req.Extra["provider"] = options.Provider
+	} else if len(defaultProviderRouting) > 0 {
+		req.Extra["provider"] = defaultProviderRouting
+	}
+	return req
+}
+
+// maxCompletionTokensModels are the OpenAI model families that reject
+// max_tokens in favor of max_completion_tokens.
+var maxCompletionTokensModels = []string{"o1", "o3", "o4", "gpt-5"}
+
+// usesMaxCompletionTokens reports whether the token limit of a model has to
+// be sent as max_completion_tokens rather than max_tokens.
+func usesMaxCompletionTokens(modelName string) bool {
+	vendor, name, ok := strings.Cut(modelName, "/")
+	if !ok {
+		name = vendor
+	} else if vendor != "openai" {
+		return false
+	}
+	for _, family := range maxCompletionTokensModels {
+		if name == family || strings.HasPrefix(name, family+"-") || strings.HasPrefix(name, family+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProviderRouting validates the OPENROUTER_PROVIDER JSON object.
+func parseProviderRouting(value string) (json.RawMessage, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var routing map[string]any
+	if err := json.Unmarshal([]byte(value), &routing); err != nil {
+		return nil, err
+	}
+	return json.RawMessage(value), nil
+}
+
+// Duration is an Ollama duration such as keep_alive, given either as a
+// duration string ("5m") or a number of seconds. Negative values mean forever.
+// Values that can't be parsed are treated as not given rather than failing the
+// request, since the proxy only uses them for /api/ps.
+type Duration struct {
+	time.Duration
+	set bool
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	var seconds float64
+	switch v := value.(type) {
+	case float64:
+		seconds = v
+	case string:
+		if parsed, err := time.ParseDuration(v); err == nil {
+			seconds = parsed.Seconds()
+		} else if seconds, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	if seconds < 0 {
+		d.Duration = time.Duration(math.MaxInt64)
+	} else {
+		d.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	d.set = true
+	return nil
+}
+
+// Or returns the duration, or the fallback if it was not given.
+func (d *Duration) Or(fallback time.Duration) time.Duration {
+	if d == nil || !d.set {
+		return fallback
+	}
+	return d.Duration
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// think and reasoning_effort are sent as a single reasoning object.
+func TestBuildChatRequestReasoning(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		think  *bool
+		effort string
+		want   string
+	}{
+		{nil, "", ""},
+		{nil, "high", `{"effort":"high"}`},
+		{&yes, "", `{"enabled":true}`},
+		{&yes, "high", `{"effort":"high"}`},
+		{&no, "", `{"effort":"low","exclude":true}`},
+		{&no, "high", `{"effort":"low","exclude":true}`},
+	}
+	for _, tt := range tests {
+		req := buildChatRequest("deepseek/deepseek-r1:free", nil, Options{Think: tt.think, ReasoningEffort: tt.effort}, UpstreamAPIChat)
+		if _, ok := req.Extra["reasoning_effort"]; ok {
+			t.Errorf("think %v, effort %q: reasoning_effort sent alongside reasoning", tt.think, tt.effort)
+		}
+		reasoning, ok := req.Extra["reasoning"]
+		if tt.want == "" {
+			if ok {
+				t.Errorf("think %v, effort %q: reasoning = %v, want none", tt.think, tt.effort, reasoning)
+			}
+			continue
+		}
+		if got, _ := json.Marshal(reasoning); string(got) != tt.want {
+			t.Errorf("think %v, effort %q: reasoning = %s, want %s", tt.think, tt.effort, got, tt.want)
+		}
+	}
+}
+
+// Backends without supported_parameters, like OpenAI's, still get
+// reasoning_effort for the o-series models, and nothing for the others.
+func TestChatReasoningEffortWithoutMetadata(t *testing.T) {
+	withTestFilter(t)
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/models" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"object":"list","data":[{"id":"o3-mini","object":"model"},{"id":"gpt-4o","object":"model"}]}`)
+			return
+		}
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		writeTestCompletion(w, "Hi")
+	}))
+	t.Cleanup(server.Close)
+	provider := NewOpenrouterProvider(server.URL+"/v1", "sk-test")
+	router := newRouter(context.Background(), routerConfig{}, provider, &ProviderRoutes{fallback: provider})
+
+	tests := []struct {
+		model      string
+		wantEffort any
+	}{
+		{"o3-mini", "high"},
+		{"gpt-4o", nil},
+	}
+	for _, tt := range tests {
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"`+tt.model+`","stream":false,"think":true,"options":{"reasoning_effort":"high"},"messages":[{"role":"user","content":"Hi"}]}`)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body %s", tt.model, recorder.Code, recorder.Body)
+		}
+		if body["reasoning_effort"] != tt.wantEffort {
+			t.Errorf("%s: reasoning_effort = %v, want %v", tt.model, body["reasoning_effort"], tt.wantEffort)
+		}
+		if reasoning, ok := body["reasoning"]; ok {
+			t.Errorf("%s: reasoning = %v, want none without metadata", tt.model, reasoning)
+		}
+	}
+}
+
+// OPENROUTER_PROVIDER is sent with requests that don't set options.provider.
+func TestChatProviderRouting(t *testing.T) {
+	var provider any
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		provider = body["provider"]
+		writeTestCompletion(w, "Hi")
+	})
+	previous := defaultProviderRouting
+	t.Cleanup(func() { defaultProviderRouting = previous })
+
+	tests := []struct {
+		env, options string
+		want         string
+	}{
+		{"", "", "null"},
+		{`{"order":["openai"]}`, "", `{"order":["openai"]}`},
+		{"", `{"order":["azure"]}`, `{"order":["azure"]}`},
+		{`{"order":["openai"]}`, `{"order":["azure"],"allow_fallbacks":false}`, `{"allow_fallbacks":false,"order":["azure"]}`},
+	}
+	for _, tt := range tests {
+		var err error
+		if defaultProviderRouting, err = parseProviderRouting(tt.env); err != nil {
+			t.Fatal(err)
+		}
+		options := `{}`
+		if tt.options != "" {
+			options = `{"provider":` + tt.options + `}`
+		}
+		provider = nil
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":false,"options":`+options+`,"messages":[{"role":"user","content":"Hi"}]}`)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+		}
+		if got, _ := json.Marshal(provider); string(got) != tt.want {
+			t.Errorf("OPENROUTER_PROVIDER %q, options.provider %q: upstream provider = %s, want %s", tt.env, tt.options, got, tt.want)
+		}
+	}
+}
@@ -2,55 +2,272 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
+// defaultKeepAlive mirrors Ollama's default time a model stays loaded after
+// its last request.
+const defaultKeepAlive = 5 * time.Minute
+
+// healthCacheDuration is how long a successful upstream health check is reused.
+const healthCacheDuration = 30 * time.Second
+
 type OpenrouterProvider struct {
 	client     *openai.Client
+	httpClient *http.Client
+	baseUrl    string
+	apiKey     string
 	modelNames []string
+
+	// modelSource provides the model list, by default the upstream one
+	modelSource ModelSource
+	// modelPrefix namespaces the models of an additional upstream, and is
+	// removed from model names sent to it
+	modelPrefix string
+
+	modelInfoMu sync.Mutex
+	modelInfo   map[string]upstreamModel // full model ID -> listed metadata
+
+	// timeout bounds non-streaming upstream calls, streamTimeout the whole
+	// lifetime of a stream
+	timeout       time.Duration
+	streamTimeout time.Duration
+
+	// embeddingsBatchSize caps the inputs sent in one upstream embeddings
+	// call, embeddingsParallelism the calls in flight for one request
+	embeddingsBatchSize   int
+	embeddingsParallelism int
+
+	firstSeenMu sync.Mutex
+	firstSeen   map[string]string // full model ID -> time first listed
+
+	runningMu sync.Mutex
+	running   map[string]time.Time // full model ID -> expiry
+
+	healthMu    sync.Mutex
+	lastHealthy time.Time
+
+	breaker *circuitBreaker
+
+	// modelsFlight shares one upstream model listing between concurrent
+	// GetModels calls, e.g. of clients polling /api/tags on a cold cache
+	modelsFlight singleflight.Group
+}
+
+// normalizeBaseUrl gives a base URL exactly one trailing slash, so that paths
+// can be appended to it whether or not the configured URL ends in one.
+func normalizeBaseUrl(baseUrl string) string {
+	return strings.TrimRight(strings.TrimSpace(baseUrl), "/") + "/"
 }
 
 func NewOpenrouterProvider(baseUrl string, apiKey string) *OpenrouterProvider {
+	baseUrl = normalizeBaseUrl(baseUrl)
 	config := openai.DefaultConfig(apiKey)
 	config.BaseURL = baseUrl
-	return &OpenrouterProvider{
-		client:     openai.NewClientWithConfig(config),
-		modelNames: []string{},
+	var base http.RoundTripper = newHTTPTransport()
+	if getEnvBool("ECHO_MODE", false) {
+		base = echoTransport{base: base}
+	}
+	breaker := newCircuitBreaker(newRetryTransport(base))
+	httpClient := &http.Client{
+		Transport: newUpstreamTransport(breaker),
+	}
+	config.HTTPClient = httpClient
+	provider := &OpenrouterProvider{
+		client:        openai.NewClientWithConfig(config),
+		httpClient:    httpClient,
+		baseUrl:       baseUrl,
+		apiKey:        apiKey,
+		modelNames:    []string{},
+		modelInfo:     make(map[string]upstreamModel),
+		breaker:       breaker,
+		timeout:       getEnvDuration("OPENAI_TIMEOUT", 2*time.Minute),
+		streamTimeout: getEnvDuration("OPENAI_STREAM_TIMEOUT", 10*time.Minute),
+		// OpenAI's limit of inputs per request
+		embeddingsBatchSize:   getEnvInt("EMBEDDINGS_BATCH_SIZE", 2048),
+		embeddingsParallelism: getEnvInt("EMBEDDINGS_PARALLELISM", 4),
+		running:               make(map[string]time.Time),
+		firstSeen:             make(map[string]string),
 	}
+	provider.modelSource = upstreamModelSource{provider: provider}
+	return provider
 }
 
-func (o *OpenrouterProvider) Chat(messages []openai.ChatCompletionMessage, modelName string) (openai.ChatCompletionResponse, error) {
-	req := openai.ChatCompletionRequest{
-		Model:    modelName,
-		Messages: messages,
-		Stream:   false,
+// ChatRequest is an OpenAI chat completion request plus fields go-openai doesn't
+// model (e.g. OpenRouter's provider routing), which are merged into the JSON
+// body sent upstream.
+type ChatRequest struct {
+	openai.ChatCompletionRequest
+	Extra map[string]any
+	API   UpstreamAPI
+}
+
+// withTimeout derives a context with the given timeout, unless it is zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ChatResponse is a chat completion response plus the reasoning of its first
+// choice, which go-openai doesn't decode.
+type ChatResponse struct {
+	openai.ChatCompletionResponse
+	Reasoning string
+}
 
-	resp, err := o.client.CreateChatCompletion(context.Background(), req)
+func (o *OpenrouterProvider) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	req.Stream = false
+	req.Model = strings.TrimPrefix(req.Model, o.modelPrefix)
+
+	ctx, cancel := withTimeout(ctx, o.timeout)
+	defer cancel()
+
+	var resp ChatResponse
+	var raw []byte
+	var err error
+	ctx = withRawBody(withExtraBody(ctx, req.Extra), &raw)
+	if req.API == UpstreamAPICompletions {
+		var completion openai.CompletionResponse
+		completion, err = o.client.CreateCompletion(ctx, toCompletionRequest(req.ChatCompletionRequest))
+		resp.ChatCompletionResponse = toChatResponse(completion)
+	} else {
+		resp.ChatCompletionResponse, err = o.client.CreateChatCompletion(ctx, req.ChatCompletionRequest)
+	}
 	if err != nil {
-		return openai.ChatCompletionResponse{}, err
+		upstreamErrorsTotal.WithLabelValues("chat", modelLabel(o.modelPrefix+req.Model)).Inc()
+		return ChatResponse{}, err
 	}
 
+	resp.Reasoning = messageReasoning(raw)
 	return resp, nil
 }
 
-func (o *OpenrouterProvider) ChatStream(messages []openai.ChatCompletionMessage, modelName string) (*openai.ChatCompletionStream, error) {
-	req := openai.ChatCompletionRequest{
-		Model:    modelName,
-		Messages: messages,
-		Stream:   true,
+// ChatCompletionStream is a stream of chat completion chunks (or completion
+// chunks, if completions is set) whose deadline is released on Close.
+type ChatCompletionStream struct {
+	stream interface {
+		RecvRaw() ([]byte, error)
+		Close() error
+	}
+	completions bool
+	cancel      context.CancelFunc
+}
+
+func (s *ChatCompletionStream) RecvRaw() ([]byte, error) {
+	return s.stream.RecvRaw()
+}
+
+func (s *ChatCompletionStream) Close() error {
+	defer s.cancel()
+	return s.stream.Close()
+}
+
+func (o *OpenrouterProvider) ChatStream(ctx context.Context, req ChatRequest) (*ChatCompletionStream, error) {
+	req.Stream = true
+	req.Model = strings.TrimPrefix(req.Model, o.modelPrefix)
+
+	ctx, cancel := withTimeout(ctx, o.streamTimeout)
+	if req.API == UpstreamAPICompletions {
+		stream, err := o.client.CreateCompletionStream(withExtraBody(ctx, req.Extra), toCompletionRequest(req.ChatCompletionRequest))
+		if err != nil {
+			cancel()
+			upstreamErrorsTotal.WithLabelValues("chat_stream", modelLabel(o.modelPrefix+req.Model)).Inc()
+			return nil, err
+		}
+		return &ChatCompletionStream{stream: stream, completions: true, cancel: cancel}, nil
 	}
 
-	stream, err := o.client.CreateChatCompletionStream(context.Background(), req)
+	stream, err := o.client.CreateChatCompletionStream(withExtraBody(ctx, req.Extra), req.ChatCompletionRequest)
 	if err != nil {
+		cancel()
+		upstreamErrorsTotal.WithLabelValues("chat_stream", modelLabel(o.modelPrefix+req.Model)).Inc()
 		return nil, err
 	}
 
-	return stream, nil
+	return &ChatCompletionStream{stream: stream, cancel: cancel}, nil
+}
+
+// Embeddings returns the embeddings of the inputs, in their order. Inputs
+// beyond the batch size are split into several upstream calls, made in
+// parallel, whose results and usage are combined. If any call fails, the
+// whole request does.
+func (o *OpenrouterProvider) Embeddings(ctx context.Context, model string, inputs []string, user string) (openai.EmbeddingResponse, error) {
+	model = strings.TrimPrefix(model, o.modelPrefix)
+
+	ctx, cancel := withTimeout(ctx, o.timeout)
+	defer cancel()
+
+	batchSize := o.embeddingsBatchSize
+	if batchSize <= 0 || batchSize > len(inputs) {
+		batchSize = max(len(inputs), 1)
+	}
+	batches := slices.Collect(slices.Chunk(inputs, batchSize))
+	responses := make([]openai.EmbeddingResponse, len(batches))
+
+	group, ctx := errgroup.WithContext(ctx)
+	group.SetLimit(max(o.embeddingsParallelism, 1))
+	for i, batch := range batches {
+		group.Go(func() error {
+			resp, err := o.embedBatch(ctx, model, batch, user)
+			if err != nil && len(batches) > 1 {
+				start := i * batchSize
+				return fmt.Errorf("embedding inputs %d to %d of %d: %w", start+1, start+len(batch), len(inputs), err)
+			}
+			responses[i] = resp
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return openai.EmbeddingResponse{}, err
+	}
+
+	resp := responses[0]
+	resp.Data = make([]openai.Embedding, 0, len(inputs))
+	resp.Usage = openai.Usage{}
+	for _, batch := range responses {
+		for _, embedding := range batch.Data {
+			// indexes of the whole input list rather than of the batch
+			embedding.Index = len(resp.Data)
+			resp.Data = append(resp.Data, embedding)
+		}
+		resp.Usage.PromptTokens += batch.Usage.PromptTokens
+		resp.Usage.CompletionTokens += batch.Usage.CompletionTokens
+		resp.Usage.TotalTokens += batch.Usage.TotalTokens
+	}
+	return resp, nil
+}
+
+// embedBatch makes a single upstream embeddings call.
+func (o *OpenrouterProvider) embedBatch(ctx context.Context, model string, inputs []string, user string) (openai.EmbeddingResponse, error) {
+	resp, err := o.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: inputs,
+		Model: openai.EmbeddingModel(model),
+		User:  user,
+	})
+	if err != nil {
+		upstreamErrorsTotal.WithLabelValues("embeddings", modelLabel(o.modelPrefix+model)).Inc()
+		return openai.EmbeddingResponse{}, err
+	}
+	if len(resp.Data) != len(inputs) {
+		upstreamErrorsTotal.WithLabelValues("embeddings", modelLabel(o.modelPrefix+model)).Inc()
+		return openai.EmbeddingResponse{}, fmt.Errorf("upstream returned %d embeddings for %d inputs", len(resp.Data), len(inputs))
+	}
+	slices.SortFunc(resp.Data, func(a, b openai.Embedding) int { return a.Index - b.Index })
+	return resp, nil
 }
 
 type ModelDetails struct {
@@ -63,6 +280,8 @@ type ModelDetails struct {
 }
 
 type Model struct {
+	// ID is the full upstream model ID, e.g. "openai/gpt-4o".
+	ID         string       `json:"-"`
 	Name       string       `json:"name"`
 	Model      string       `json:"model,omitempty"`
 	ModifiedAt string       `json:"modified_at,omitempty"`
@@ -71,84 +290,434 @@ type Model struct {
 	Details    ModelDetails `json:"details,omitempty"`
 }
 
+// ModelOrder is the order in which /api/tags lists models.
+type ModelOrder string
+
+const (
+	// ModelOrderName sorts by name, then full ID (the default).
+	ModelOrderName ModelOrder = "name"
+	// ModelOrderModified lists the most recently modified models first.
+	ModelOrderModified ModelOrder = "modified"
+	// ModelOrderUpstream keeps the order of the model source.
+	ModelOrderUpstream ModelOrder = "upstream"
+)
+
+func parseModelOrder(value string) (ModelOrder, error) {
+	switch order := ModelOrder(value); order {
+	case "":
+		return ModelOrderName, nil
+	case ModelOrderName, ModelOrderModified, ModelOrderUpstream:
+		return order, nil
+	default:
+		return "", fmt.Errorf("unknown model order %q", value)
+	}
+}
+
+// sortModels sorts the models in place. Ties are broken by full ID, so the
+// result doesn't depend on the order the models were listed in.
+func sortModels(models []Model, order ModelOrder) {
+	byID := func(a, b Model) int { return strings.Compare(a.ID, b.ID) }
+	switch order {
+	case ModelOrderName:
+		slices.SortFunc(models, func(a, b Model) int {
+			if c := strings.Compare(a.Name, b.Name); c != 0 {
+				return c
+			}
+			return byID(a, b)
+		})
+	case ModelOrderModified:
+		slices.SortFunc(models, func(a, b Model) int {
+			aTime, _ := time.Parse(time.RFC3339, a.ModifiedAt)
+			bTime, _ := time.Parse(time.RFC3339, b.ModifiedAt)
+			if c := bTime.Compare(aTime); c != 0 {
+				return c
+			}
+			return byID(a, b)
+		})
+	}
+}
+
+// GetModels lists the upstream models and refreshes the cached model names
+// and metadata. Concurrent calls share a single upstream request.
 func (o *OpenrouterProvider) GetModels() ([]Model, error) {
+	models, err, _ := o.modelsFlight.Do("models", func() (any, error) {
+		return o.fetchModels()
+	})
+	if err != nil {
+		return nil, err
+	}
+	// every caller gets its own copy, as the list is sorted in place
+	return slices.Clone(models.([]Model)), nil
+}
+
+func (o *OpenrouterProvider) fetchModels() ([]Model, error) {
 	currentTime := time.Now().Format(time.RFC3339)
 
-	modelsResponse, err := o.client.ListModels(context.Background())
+	ctx, cancel := withTimeout(context.Background(), o.timeout)
+	defer cancel()
+
+	apiModels, err := o.modelSource.ListModels(ctx)
 	if err != nil {
+		upstreamErrorsTotal.WithLabelValues("list_models", "").Inc()
 		return nil, err
 	}
 
-	o.modelNames = []string{}
+	o.firstSeenMu.Lock()
+	defer o.firstSeenMu.Unlock()
+	o.modelInfoMu.Lock()
+	defer o.modelInfoMu.Unlock()
 
 	var models []Model
-	for _, apiModel := range modelsResponse.Models {
-		parts := strings.Split(apiModel.ID, "/")
-		name := parts[len(parts)-1]
-
-		o.modelNames = append(o.modelNames, apiModel.ID)
-
-		model := Model{
-			Name:       name,
-			Model:      name,
-			ModifiedAt: currentTime,
-			Size:       0,
-			Digest:     name,
-			Details: ModelDetails{
-				ParentModel:       "",
-				Format:            "gguf",
-				Family:            "claude",
-				Families:          []string{"claude"},
-				ParameterSize:     "175B",
-				QuantizationLevel: "Q4_K_M",
-			},
+	names := make([]string, 0, len(apiModels))
+	for _, apiModel := range apiModels {
+		names = append(names, apiModel.ID)
+		o.modelInfo[apiModel.ID] = apiModel
+		listedModels.Store(apiModel.ID, struct{}{})
+
+		model := newModel(apiModel.ID, o.modifiedAt(apiModel.Model, currentTime))
+		if apiModel.namespace != "" {
+			model.Name = apiModel.namespace + model.Name
+			model.Model = model.Name
 		}
 		models = append(models, model)
 	}
+	// swapped in whole, so that concurrent lookups never see a partial list
+	o.modelNames = names
 
 	return models, nil
 }
 
+// cachedModelNames returns the full IDs of the last model listing. The slice
+// is replaced rather than modified by refreshes, so callers may keep using it
+// after the lock is released.
+func (o *OpenrouterProvider) cachedModelNames() []string {
+	o.modelInfoMu.Lock()
+	defer o.modelInfoMu.Unlock()
+	return o.modelNames
+}
+
+// upstreamModel is a listed model including the metadata OpenRouter reports
+// beyond the OpenAI model object.
+type upstreamModel struct {
+	openai.Model
+	ContextLength int `json:"context_length"`
+	Architecture  struct {
+		InputModalities []string `json:"input_modalities"`
+	} `json:"architecture"`
+	SupportedParameters []string `json:"supported_parameters"`
+
+	// namespace is the prefix of a model listed from an additional upstream,
+	// kept in its short name to tell it apart from the default upstream's
+	namespace string
+}
+
+// listModels fetches the model list itself rather than via go-openai, whose
+// model type drops OpenRouter's metadata. Error responses are returned as an
+// *openai.APIError, like from other client calls.
+func (o *OpenrouterProvider) listModels(ctx context.Context) ([]upstreamModel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.baseUrl+"models", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		var errResp openai.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == nil {
+			return nil, &openai.RequestError{HTTPStatus: resp.Status, HTTPStatusCode: resp.StatusCode, Err: fmt.Errorf("listing models failed")}
+		}
+		errResp.Error.HTTPStatus = resp.Status
+		errResp.Error.HTTPStatusCode = resp.StatusCode
+		return nil, errResp.Error
+	}
+
+	var list struct {
+		Data []upstreamModel `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list.Data, nil
+}
+
+// modelInfoFor returns the listed metadata of a model, if known.
+func (o *OpenrouterProvider) modelInfoFor(fullName string) (upstreamModel, bool) {
+	o.modelInfoMu.Lock()
+	defer o.modelInfoMu.Unlock()
+	info, ok := o.modelInfo[fullName]
+	return info, ok
+}
+
+// modelSupportsVision reports whether a model accepts image input. Models
+// whose input modalities aren't reported are assumed to support it, leaving
+// the decision to the backend.
+func (o *OpenrouterProvider) modelSupportsVision(fullName string) bool {
+	info, ok := o.modelInfoFor(fullName)
+	if !ok || len(info.Architecture.InputModalities) == 0 {
+		return true
+	}
+	return slices.Contains(info.Architecture.InputModalities, "image")
+}
+
+// modelSupportsReasoning reports whether a model is listed as accepting
+// OpenRouter's reasoning parameters.
+func (o *OpenrouterProvider) modelSupportsReasoning(fullName string) bool {
+	info, ok := o.modelInfoFor(fullName)
+	return ok && (slices.Contains(info.SupportedParameters, "reasoning") || slices.Contains(info.SupportedParameters, "include_reasoning"))
+}
+
+// modelListsParameters reports whether the backend lists the parameters a
+// model supports, which OpenAI's own models endpoint doesn't.
+func (o *OpenrouterProvider) modelListsParameters(fullName string) bool {
+	info, ok := o.modelInfoFor(fullName)
+	return ok && len(info.SupportedParameters) > 0
+}
+
+// capabilitiesFor derives the Ollama capabilities of a model from its listed
+// metadata, so that clients only offer e.g. tool use for models supporting it.
+// Models without metadata are only known to complete.
+func (o *OpenrouterProvider) capabilitiesFor(fullName string) []string {
+	info, ok := o.modelInfoFor(fullName)
+	if !ok {
+		return []string{"completion"}
+	}
+	if strings.Contains(fullName, "embed") {
+		return []string{"embedding"}
+	}
+
+	capabilities := []string{"completion"}
+	if slices.Contains(info.SupportedParameters, "tools") {
+		capabilities = append(capabilities, "tools")
+	}
+	if slices.Contains(info.Architecture.InputModalities, "image") {
+		capabilities = append(capabilities, "vision")
+	}
+	if o.modelSupportsReasoning(fullName) {
+		capabilities = append(capabilities, "thinking")
+	}
+	return capabilities
+}
+
+// visionAlternative suggests a vision-capable model, preferring one of the
+// same vendor as the given model.
+func (o *OpenrouterProvider) visionAlternative(fullName string) string {
+	o.modelInfoMu.Lock()
+	defer o.modelInfoMu.Unlock()
+
+	vendor, _, _ := strings.Cut(fullName, "/")
+	var fallback string
+	for _, id := range o.modelNames {
+		info := o.modelInfo[id]
+		if !slices.Contains(info.Architecture.InputModalities, "image") {
+			continue
+		}
+		if strings.HasPrefix(id, vendor+"/") {
+			return id
+		}
+		if fallback == "" {
+			fallback = id
+		}
+	}
+	return fallback
+}
+
+// CheckHealth verifies the upstream is reachable by listing its models, which
+// also refreshes the cached model names. Successful checks are reused for
+// healthCacheDuration to keep frequent probes cheap.
+func (o *OpenrouterProvider) CheckHealth() error {
+	o.healthMu.Lock()
+	defer o.healthMu.Unlock()
+
+	if time.Since(o.lastHealthy) < healthCacheDuration {
+		return nil
+	}
+	if _, err := o.GetModels(); err != nil {
+		return err
+	}
+	o.lastHealthy = time.Now()
+	return nil
+}
+
+// CircuitState reports the state of the circuit breaker guarding the upstream.
+func (o *OpenrouterProvider) CircuitState() circuitState {
+	return o.breaker.State()
+}
+
+// modifiedAt returns the upstream creation time of a model if reported, or
+// else the time the model was first listed. Either way it is stable across
+// refreshes, so the model list only changes when the upstream catalog does.
+// Callers must hold firstSeenMu.
+func (o *OpenrouterProvider) modifiedAt(apiModel openai.Model, currentTime string) string {
+	if apiModel.CreatedAt > 0 {
+		return time.Unix(apiModel.CreatedAt, 0).UTC().Format(time.RFC3339)
+	}
+
+	modifiedAt, ok := o.firstSeen[apiModel.ID]
+	if !ok {
+		modifiedAt = currentTime
+		o.firstSeen[apiModel.ID] = modifiedAt
+	}
+	return modifiedAt
+}
+
+func newModel(id string, modifiedAt string) Model {
+	parts := strings.Split(id, "/")
+	name := parts[len(parts)-1]
+
+	details := ModelDetails{
+		ParentModel:       "",
+		Format:            "gguf",
+		Family:            "claude",
+		Families:          []string{"claude"},
+		ParameterSize:     "175B",
+		QuantizationLevel: "Q4_K_M",
+	}
+	size := defaultModelSize
+	if count, ok := parseParameterCount(id); ok {
+		details.ParameterSize = formatParameterSize(count)
+		size = estimateModelSize(count, details.QuantizationLevel)
+	}
+
+	return Model{
+		ID:         id,
+		Name:       name,
+		Model:      name,
+		ModifiedAt: modifiedAt,
+		Size:       size,
+		Digest:     modelDigest(id),
+		Details:    details,
+	}
+}
+
+type RunningModel struct {
+	Model     Model
+	ExpiresAt time.Time
+}
+
+// TouchModel records a request for the given model, keeping it in the list of
+// running models for the keep-alive duration. A zero keep-alive unloads it.
+func (o *OpenrouterProvider) TouchModel(fullName string, keepAlive time.Duration) {
+	o.runningMu.Lock()
+	defer o.runningMu.Unlock()
+
+	if keepAlive == 0 {
+		delete(o.running, fullName)
+		return
+	}
+
+	o.running[fullName] = time.Now().Add(keepAlive)
+}
+
+// RunningModels returns the models requested within their keep-alive
+// duration, most recently expiring first.
+func (o *OpenrouterProvider) RunningModels() []RunningModel {
+	o.runningMu.Lock()
+	defer o.runningMu.Unlock()
+
+	now := time.Now()
+	models := make([]RunningModel, 0, len(o.running))
+	for fullName, expiresAt := range o.running {
+		if !expiresAt.After(now) {
+			delete(o.running, fullName)
+			continue
+		}
+		models = append(models, RunningModel{
+			Model:     newModel(fullName, ""),
+			ExpiresAt: expiresAt,
+		})
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ExpiresAt.After(models[j].ExpiresAt)
+	})
+	return models
+}
+
+// errModelNotFound is returned for models that aren't listed.
+var errModelNotFound = errors.New("not found")
+
 func (o *OpenrouterProvider) GetModelDetails(modelName string) (map[string]interface{}, error) {
 	currentTime := time.Now().Format(time.RFC3339)
 
+	contextLength := 200000
+	fullName, err := o.GetFullModelName(modelName)
+	if err != nil {
+		return nil, err
+	}
+	info, ok := o.modelInfoFor(fullName)
+	if !ok {
+		return nil, fmt.Errorf("model '%s' %w", modelName, errModelNotFound)
+	}
+	if info.ContextLength > 0 {
+		contextLength = info.ContextLength
+	}
+
+	// same size, digest and details as listed by /api/tags
+	model := newModel(fullName, currentTime)
+	parameterCount, ok := parseParameterCount(fullName)
+	if !ok {
+		parameterCount = 175_000_000_000
+	}
+
 	return map[string]interface{}{
 		"license":    "STUB License",
 		"system":     "STUB SYSTEM",
 		"modifiedAt": currentTime,
-		"details": map[string]interface{}{
-			"format":             "gguf",
-			"parameter_size":     "200B",
-			"quantization_level": "Q4_K_M",
-		},
+		"size":       model.Size,
+		"digest":     model.Digest,
+		"details":    model.Details,
 		"model_info": map[string]interface{}{
 			"architecture":    "STUB",
-			"context_length":  200000,
-			"parameter_count": 200_000_000_000,
+			"context_length":  contextLength,
+			"parameter_count": int64(parameterCount),
 		},
-		"capabilities": []string{"completion", "tools", "insert"},
+		"capabilities": o.capabilitiesFor(fullName),
 	}, nil
 }
 
 func (o *OpenrouterProvider) GetFullModelName(alias string) (string, error) {
-	if len(o.modelNames) == 0 {
+	// loaded before resolving aliases too, whose targets' metadata callers
+	// look up
+	modelNames := o.cachedModelNames()
+	if len(modelNames) == 0 {
 		_, err := o.GetModels()
 		if err != nil {
 			return "", fmt.Errorf("failed to get models: %w", err)
 		}
+		modelNames = o.cachedModelNames()
 	}
 
-	for _, fullName := range o.modelNames {
+	if target, ok := modelAliases.Resolve(alias); ok {
+		return target, nil
+	}
+
+	for _, fullName := range modelNames {
 		if fullName == alias {
 			return fullName, nil
 		}
 	}
 
-	for _, fullName := range o.modelNames {
+	for _, fullName := range modelNames {
 		if strings.HasSuffix(fullName, alias) {
 			return fullName, nil
 		}
 	}
 
+	// namespaced short names, e.g. local/gpt-4o for local/openai/gpt-4o
+	if namespace, name, ok := strings.Cut(alias, "/"); ok {
+		for _, fullName := range modelNames {
+			if strings.HasPrefix(fullName, namespace+"/") && strings.HasSuffix(fullName, "/"+name) {
+				return fullName, nil
+			}
+		}
+	}
+
 	return alias, nil
 }
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// testModels is the model list served by newTestUpstream.
+var testModels = []map[string]any{
+	{"id": "openai/gpt-4o", "object": "model", "created": 1715558400, "architecture": map[string]any{"input_modalities": []string{"text", "image"}}, "supported_parameters": []string{"tools"}},
+	{"id": "deepseek/deepseek-r1:free", "object": "model", "created": 1737331200, "supported_parameters": []string{"reasoning"}},
+	{"id": "anthropic/claude-3.5-sonnet", "object": "model", "created": 1718841600},
+}
+
+// newTestUpstream starts a fake OpenAI-compatible upstream serving testModels
+// on /v1/models and everything else with handler, which may be nil. It
+// returns the server and a counter of model list requests.
+func newTestUpstream(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var listed atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/v1/models" {
+			listed.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": testModels})
+			return
+		}
+		if handler == nil {
+			http.NotFound(w, r)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &listed
+}
+
+func newTestProvider(t *testing.T, handler http.HandlerFunc) (*OpenrouterProvider, *atomic.Int32) {
+	t.Helper()
+	server, listed := newTestUpstream(t, handler)
+	return NewOpenrouterProvider(server.URL+"/v1", "sk-test"), listed
+}
+
+// withTestAliases replaces the global aliases for the duration of a test.
+func withTestAliases(t *testing.T, aliases map[string]string) {
+	t.Helper()
+	previous := modelAliases
+	modelAliases = &ModelAliases{targets: make(map[string]string)}
+	for name, target := range aliases {
+		modelAliases.Set(name, target)
+	}
+	t.Cleanup(func() { modelAliases = previous })
+}
+
+func TestGetFullModelName(t *testing.T) {
+	provider, _ := newTestProvider(t, nil)
+	withTestAliases(t, map[string]string{"gpt4": "openai/gpt-4o"})
+
+	tests := []struct {
+		in, want string
+	}{
+		{"openai/gpt-4o", "openai/gpt-4o"},
+		{"gpt-4o", "openai/gpt-4o"},
+		{"deepseek-r1:free", "deepseek/deepseek-r1:free"},
+		{"gpt4", "openai/gpt-4o"},
+		{"unknown-model", "unknown-model"},
+	}
+	for _, tt := range tests {
+		got, err := provider.GetFullModelName(tt.in)
+		if err != nil {
+			t.Fatalf("GetFullModelName(%q): %v", tt.in, err)
+		}
+		if got != tt.want {
+			t.Errorf("GetFullModelName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// On a cold cache, resolving an alias loads the model list, so that the
+// metadata of its target is known.
+func TestGetFullModelNameAliasLoadsModels(t *testing.T) {
+	provider, listed := newTestProvider(t, nil)
+	withTestAliases(t, map[string]string{"gpt4": "openai/gpt-4o"})
+
+	got, err := provider.GetFullModelName("gpt4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "openai/gpt-4o" {
+		t.Errorf("GetFullModelName(gpt4) = %q, want openai/gpt-4o", got)
+	}
+	if n := listed.Load(); n != 1 {
+		t.Errorf("upstream listed %d times, want 1", n)
+	}
+	if _, ok := provider.modelInfoFor(got); !ok {
+		t.Error("no metadata for the alias target after resolving it")
+	}
+	if _, err := provider.GetModelDetails("gpt4"); err != nil {
+		t.Errorf("GetModelDetails(gpt4): %v", err)
+	}
+}
+
+// Concurrent refreshes and lookups must neither race nor see a partial list;
+// run with -race.
+func TestGetFullModelNameDuringRefresh(t *testing.T) {
+	provider, _ := newTestProvider(t, nil)
+	if _, err := provider.GetModels(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := provider.GetModels(); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			got, err := provider.GetFullModelName("gpt-4o")
+			if err != nil {
+				t.Error(err)
+			} else if got != "openai/gpt-4o" {
+				t.Errorf("GetFullModelName during refresh = %q, want openai/gpt-4o", got)
+			}
+			provider.visionAlternative("anthropic/claude-3.5-sonnet")
+		}()
+	}
+	wg.Wait()
+}
+
+// Concurrent model list requests share one upstream call.
+func TestGetModelsSingleFlight(t *testing.T) {
+	release := make(chan struct{})
+	var listed atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listed.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": testModels})
+	}))
+	t.Cleanup(server.Close)
+	provider := NewOpenrouterProvider(server.URL+"/v1", "sk-test")
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			models, err := provider.GetModels()
+			if err != nil {
+				t.Error(err)
+			} else if len(models) != len(testModels) {
+				t.Errorf("got %d models, want %d", len(models), len(testModels))
+			}
+		}()
+	}
+	// let the callers pile up on the first request
+	for listed.Load() == 0 {
+		runtime.Gosched()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := listed.Load(); n != 1 {
+		t.Errorf("upstream listed %d times, want 1", n)
+	}
+}
+
+func TestNormalizeBaseUrl(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://openrouter.ai/api/v1/", "https://openrouter.ai/api/v1/"},
+		{"https://openrouter.ai/api/v1", "https://openrouter.ai/api/v1/"},
+		{"https://openrouter.ai/api/v1//", "https://openrouter.ai/api/v1/"},
+		{" http://localhost:8080/gateway/openai/v1 ", "http://localhost:8080/gateway/openai/v1/"},
+		{"http://localhost:8080", "http://localhost:8080/"},
+	}
+	for _, tt := range tests {
+		if got := normalizeBaseUrl(tt.in); got != tt.want {
+			t.Errorf("normalizeBaseUrl(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// Base URLs with a path reach the upstream under that path, with or without
+// a trailing slash.
+func TestBaseUrlPath(t *testing.T) {
+	server, listed := newTestUpstream(t, nil)
+	for _, baseUrl := range []string{server.URL + "/v1", server.URL + "/v1/"} {
+		if _, err := NewOpenrouterProvider(baseUrl, "sk-test").GetModels(); err != nil {
+			t.Errorf("base URL %q: %v", baseUrl, err)
+		}
+	}
+	if n := listed.Load(); n != 2 {
+		t.Errorf("upstream listed %d times, want 2", n)
+	}
+}
+
+// Models are listed as modified when the upstream created them.
+func TestTagsModifiedAt(t *testing.T) {
+	router := newTestRouter(t, routerConfig{}, nil)
+
+	want := map[string]string{
+		"gpt-4o":            "2024-05-13T00:00:00Z",
+		"deepseek-r1:free":  "2025-01-20T00:00:00Z",
+		"claude-3.5-sonnet": "2024-06-20T00:00:00Z",
+	}
+	models := tagsModels(t, router)
+	if len(models) != len(want) {
+		t.Fatalf("got %d models, want %d", len(models), len(want))
+	}
+	for _, m := range models {
+		if modifiedAt := want[m["model"].(string)]; m["modified_at"] != modifiedAt {
+			t.Errorf("%s: modified_at = %v, want %s", m["model"], m["modified_at"], modifiedAt)
+		}
+	}
+}
+
+// Without a created time, models are listed as modified when first seen.
+func TestModifiedAtFirstSeen(t *testing.T) {
+	provider, _ := newTestProvider(t, nil)
+	provider.firstSeenMu.Lock()
+	defer provider.firstSeenMu.Unlock()
+
+	first := provider.modifiedAt(openai.Model{ID: "local/llama3"}, "2026-01-01T00:00:00Z")
+	again := provider.modifiedAt(openai.Model{ID: "local/llama3"}, "2026-02-01T00:00:00Z")
+	if first != "2026-01-01T00:00:00Z" || again != first {
+		t.Errorf("modified_at = %s, then %s, want the time first seen both times", first, again)
+	}
+	if created := provider.modifiedAt(openai.Model{ID: "local/phi3", CreatedAt: 1700000000}, "2026-01-01T00:00:00Z"); created != "2023-11-14T22:13:20Z" {
+		t.Errorf("modified_at = %s, want the created time", created)
+	}
+}
+
+// The models are listed in the same order however the upstream orders them.
+func TestTagsOrderStable(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// rotate the catalog on every listing
+		n := int(calls.Add(1))
+		models := append(slices.Clone(testModels[n%len(testModels):]), testModels[:n%len(testModels)]...)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": models})
+	}))
+	t.Cleanup(server.Close)
+	withTestFilter(t)
+
+	tests := map[ModelOrder][]string{
+		ModelOrderName:     {"claude-3.5-sonnet", "deepseek-r1:free", "gpt-4o"},
+		ModelOrderModified: {"deepseek-r1:free", "claude-3.5-sonnet", "gpt-4o"},
+	}
+	for order, want := range tests {
+		provider := NewOpenrouterProvider(server.URL+"/v1", "sk-test")
+		router := newRouter(context.Background(), routerConfig{modelOrder: order}, provider, &ProviderRoutes{fallback: provider})
+		for range len(testModels) {
+			// every request lists anew, as the provider caches no models
+			if got := tagsModelNames(t, router); !slices.Equal(got, want) {
+				t.Errorf("order %s: tags = %q, want %q", order, got, want)
+			}
+		}
+	}
+	if n := calls.Load(); n < int32(2*len(testModels)) {
+		t.Errorf("upstream listed %d times, want a listing per request", n)
+	}
+}
+
+// Inputs beyond EMBEDDINGS_BATCH_SIZE are embedded in several calls, whose
+// results keep the order of the inputs and whose usage is summed.
+func TestEmbeddingsBatches(t *testing.T) {
+	t.Setenv("EMBEDDINGS_BATCH_SIZE", "2")
+	t.Setenv("EMBEDDINGS_PARALLELISM", "2")
+	t.Setenv("UPSTREAM_MAX_ATTEMPTS", "1")
+	var mu sync.Mutex
+	var batches [][]string
+	provider, _ := newTestProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		mu.Lock()
+		batches = append(batches, request.Input)
+		mu.Unlock()
+		if slices.Contains(request.Input, "fail") {
+			http.Error(w, `{"error":{"message":"upstream overloaded"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		// in reverse, each vector holding the length of its input
+		var data []map[string]any
+		for i := len(request.Input) - 1; i >= 0; i-- {
+			data = append(data, map[string]any{"object": "embedding", "index": i, "embedding": []float32{float32(len(request.Input[i]))}})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"object": "list", "model": "openai/text-embedding-3-small", "data": data,
+			"usage": map[string]any{"prompt_tokens": len(request.Input), "total_tokens": len(request.Input)},
+		})
+	})
+
+	inputs := []string{"a", "bb", "ccc", "dddd", "eeeee"}
+	resp, err := provider.Embeddings(context.Background(), "openai/text-embedding-3-small", inputs, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 3 {
+		t.Errorf("upstream calls = %q, want 3 batches of at most 2", batches)
+	}
+	if len(resp.Data) != len(inputs) {
+		t.Fatalf("got %d embeddings, want %d", len(resp.Data), len(inputs))
+	}
+	for i, embedding := range resp.Data {
+		if embedding.Index != i || embedding.Embedding[0] != float32(len(inputs[i])) {
+			t.Errorf("embedding %d = index %d, %v, want the embedding of %q", i, embedding.Index, embedding.Embedding, inputs[i])
+		}
+	}
+	if resp.Usage.PromptTokens != 5 || resp.Usage.TotalTokens != 5 {
+		t.Errorf("usage = %+v, want the sum of all batches", resp.Usage)
+	}
+
+	_, err = provider.Embeddings(context.Background(), "openai/text-embedding-3-small", []string{"a", "bb", "fail", "dddd"}, "")
+	if err == nil || !strings.Contains(err.Error(), "embedding inputs 3 to 4 of 4") {
+		t.Errorf("err = %v, want the failed batch", err)
+	}
+	if status, message := upstreamError(err); status != http.StatusBadGateway || message != "upstream overloaded" {
+		t.Errorf("upstream error = %d %q, want the status and message of the failed batch", status, message)
+	}
+}
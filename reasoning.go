@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// reasoningFields holds the reasoning some backends return next to the
+// content: OpenRouter as "reasoning", DeepSeek and vLLM as
+// "reasoning_content".
+type reasoningFields struct {
+	Reasoning        string `json:"reasoning"`
+	ReasoningContent string `json:"reasoning_content"`
+}
+
+func (r reasoningFields) text() string {
+	if r.Reasoning != "" {
+		return r.Reasoning
+	}
+	return r.ReasoningContent
+}
+
+// messageReasoning returns the reasoning of the first choice of a raw chat
+// completion response.
+func messageReasoning(raw []byte) string {
+	var body struct {
+		Choices []struct {
+			Index   int             `json:"index"`
+			Message reasoningFields `json:"message"`
+		} `json:"choices"`
+	}
+	if json.Unmarshal(raw, &body) != nil {
+		return ""
+	}
+	for _, choice := range body.Choices {
+		if choice.Index == 0 {
+			return choice.Message.text()
+		}
+	}
+	return ""
+}
+
+// deltaReasoning returns the reasoning delta of the first choice of a raw
+// stream chunk.
+func deltaReasoning(raw []byte) string {
+	var body struct {
+		Choices []struct {
+			Index int             `json:"index"`
+			Delta reasoningFields `json:"delta"`
+		} `json:"choices"`
+	}
+	if json.Unmarshal(raw, &body) != nil {
+		return ""
+	}
+	for _, choice := range body.Choices {
+		if choice.Index == 0 {
+			return choice.Delta.text()
+		}
+	}
+	return ""
+}
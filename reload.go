@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+)
+
+// defaultModelFilterPath is the models filter file unless set by --filter or
+// MODELS_FILTER_PATH.
+const defaultModelFilterPath = "models-filter"
+
+var modelFilterPath = defaultModelFilterPath
+
+// modelFilter is replaced as a whole when the models-filter file is reloaded.
+var modelFilter atomic.Pointer[ModelFilter]
+
+// defaultEmbeddingsFilterPath is the embeddings filter file unless set by
+// EMBEDDINGS_FILTER_PATH.
+const defaultEmbeddingsFilterPath = "embeddings-filter"
+
+var embeddingsFilterPath = defaultEmbeddingsFilterPath
+
+// embeddingsFilter restricts the models of embeddings requests, like
+// modelFilter does the listed ones.
+var embeddingsFilter atomic.Pointer[ModelFilter]
+
+// loadFilterFile (re)loads the models-filter file. A missing file disables
+// filtering.
+func loadFilterFile() error {
+	return loadFilterInto(&modelFilter, modelFilterPath, "models-filter")
+}
+
+// loadEmbeddingsFilterFile (re)loads the embeddings-filter file. A missing
+// file allows every model for embeddings.
+func loadEmbeddingsFilterFile() error {
+	return loadFilterInto(&embeddingsFilter, embeddingsFilterPath, "embeddings-filter")
+}
+
+func loadFilterInto(target *atomic.Pointer[ModelFilter], filterPath string, name string) error {
+	// logged absolute, as a relative path depends on the working directory
+	path, err := filepath.Abs(filterPath)
+	if err != nil {
+		path = filterPath
+	}
+	filter, err := loadModelFilter(filterPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		slog.Info(name+" file not found. Skipping model filtering.", "path", path)
+		target.Store(NewModelFilter(nil))
+		return nil
+	}
+
+	target.Store(filter)
+	slog.Info("Loaded models from filter:", "path", path)
+	for _, model := range filter.Entries() {
+		slog.Info(" - " + model)
+	}
+	return nil
+}
+
+// loadAliasesFile (re)loads the aliases file. A missing file removes all
+// aliases.
+func loadAliasesFile() error {
+	aliases, err := loadModelAliases(modelAliasesPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		slog.Info("aliases file not found. Skipping model aliases.")
+		modelAliases.replace(&ModelAliases{targets: make(map[string]string)})
+		return nil
+	}
+
+	modelAliases.replace(aliases)
+	slog.Info("Loaded model aliases", "count", aliases.Len())
+	return nil
+}
+
+// reloadOnSIGHUP reloads the models-filter, embeddings-filter and aliases
+// files whenever the process receives SIGHUP, until ctx is done. A file that
+// fails to load keeps its previous contents.
+func reloadOnSIGHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("Received SIGHUP, reloading configuration files")
+			if err := loadFilterFile(); err != nil {
+				slog.Error("Error reloading models filter", "Error", err)
+			}
+			if err := loadEmbeddingsFilterFile(); err != nil {
+				slog.Error("Error reloading embeddings filter", "Error", err)
+			}
+			if err := loadAliasesFile(); err != nil {
+				slog.Error("Error reloading model aliases", "Error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxRetryDelay caps both the exponential backoff and Retry-After waits.
+const maxRetryDelay = 30 * time.Second
+
+// retryTransport retries upstream requests failing with 429 or a transient 5xx
+// status, using exponential backoff with jitter or the server's Retry-After.
+// Since it acts before the response body is handed out, streams are only
+// retried while being established, never mid-flight.
+type retryTransport struct {
+	base        http.RoundTripper
+	maxAttempts int
+	baseDelay   time.Duration
+}
+
+// newRetryTransport reads UPSTREAM_MAX_ATTEMPTS and UPSTREAM_RETRY_DELAY.
+func newRetryTransport(base http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		base:        base,
+		maxAttempts: max(1, getEnvInt("UPSTREAM_MAX_ATTEMPTS", 3)),
+		baseDelay:   getEnvDuration("UPSTREAM_RETRY_DELAY", 500*time.Millisecond),
+	}
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil || !isRetryableStatus(resp.StatusCode) || attempt >= t.maxAttempts {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+
+		delay := t.backoff(attempt, resp.Header.Get("Retry-After"))
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		slog.WarnContext(req.Context(), "Retrying upstream request", "status", resp.StatusCode, "attempt", attempt, "delay", delay)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt, preferring the server's
+// Retry-After (in seconds) over exponential backoff with jitter.
+func (t *retryTransport) backoff(attempt int, retryAfter string) time.Duration {
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return min(time.Duration(seconds)*time.Second, maxRetryDelay)
+	}
+	if at, err := http.ParseTime(retryAfter); err == nil {
+		return min(max(time.Until(at), 0), maxRetryDelay)
+	}
+
+	delay := min(t.baseDelay<<(attempt-1), maxRetryDelay)
+	return delay/2 + rand.N(delay/2+1)
+}
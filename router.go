@@ -0,0 +1,1094 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// routerConfig holds the settings of the served API, as read from the
+// environment by main.
+type routerConfig struct {
+	corsOrigins    []string
+	corsMethods    string
+	corsHeaders    string
+	apiKeys        []string
+	compress       bool
+	logBodies      bool
+	redactPatterns []*regexp.Regexp
+	metrics        bool
+
+	rateLimitRPM          int
+	rateLimitBurst        int
+	maxConcurrentRequests int
+	maxQueueWait          time.Duration
+	maxBodyBytes          int64
+
+	defaultModel  string
+	ollamaVersion string
+	modelOrder    ModelOrder
+	tagsMaxAge    time.Duration
+
+	maxPromptChars  int
+	maxPromptTokens int
+
+	streamPrefix         string
+	streamSuffix         string
+	streamFinalContent   bool
+	clientWriteTimeout   time.Duration
+	streamFlushFrames    int
+	streamFlushInterval  time.Duration
+	sseKeepAliveInterval time.Duration
+}
+
+// newRouter registers the middleware and routes of the proxy. Streams are
+// ended early once ctx is done, i.e. on shutdown.
+func newRouter(ctx context.Context, config routerConfig, provider *OpenrouterProvider, routes *ProviderRoutes) *gin.Engine {
+	r := gin.New()
+	r.Use(accessLog(), gin.Recovery())
+	if len(config.corsOrigins) > 0 {
+		r.Use(cors(config.corsOrigins, config.corsMethods, config.corsHeaders))
+	}
+	if len(config.apiKeys) > 0 {
+		r.Use(requireAPIKey(config.apiKeys))
+	}
+	if config.compress {
+		r.Use(compress())
+	}
+	if config.logBodies {
+		r.Use(logBodies(config.redactPatterns))
+	}
+	if config.metrics {
+		registerMetrics(r)
+	}
+
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "Ollama is running")
+	})
+	r.HEAD("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "")
+	})
+
+	r.GET("/livez", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	r.GET("/healthz", func(c *gin.Context) {
+		circuit := provider.CircuitState()
+		if circuit == circuitOpen {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": errCircuitOpen.Error(), "circuit": circuit})
+			return
+		}
+		if err := provider.CheckHealth(); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Health check failed", "Error", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": "backend unreachable: " + err.Error(), "circuit": provider.CircuitState()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "circuit": provider.CircuitState()})
+	})
+
+	r.GET("/api/version", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"version": config.ollamaVersion})
+	})
+
+	r.GET("/api/tags", func(c *gin.Context) {
+		models, err := provider.GetModels()
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error getting models", "Error", err)
+			writeUpstreamError(c, err)
+			return
+		}
+		models = withAliases(models)
+		sortModels(models, config.modelOrder)
+		filter := modelFilter.Load()
+		newModels := make([]map[string]interface{}, 0, len(models))
+		for _, m := range models {
+			if !filter.Allows(m.Model, m.ID) {
+				continue
+			}
+			newModels = append(newModels, map[string]interface{}{
+				"name":        m.Name,
+				"model":       m.Model,
+				"modified_at": m.ModifiedAt,
+				"size":        m.Size,
+				"digest":      m.Digest,
+				"details":     m.Details,
+			})
+		}
+
+		body, err := json.Marshal(gin.H{"models": newModels})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error marshaling models JSON", "Error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		etag := computeETag(body)
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", fmt.Sprintf("max-age=%d", int(config.tagsMaxAge.Seconds())))
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	})
+
+	// the model list is fetched on demand, so refreshing re-fetches it right
+	// away, e.g. to pick up new upstream models or test a filter
+	r.POST("/api/refresh-models", func(c *gin.Context) {
+		models, err := provider.GetModels()
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error refreshing models", "Error", err)
+			writeUpstreamError(c, err)
+			return
+		}
+		filter := modelFilter.Load()
+		count := 0
+		for _, m := range withAliases(models) {
+			if filter.Allows(m.Model, m.ID) {
+				count++
+			}
+		}
+		slog.InfoContext(c.Request.Context(), "Refreshed models", "count", count)
+		c.JSON(http.StatusOK, gin.H{"count": count})
+	})
+
+	r.GET("/api/ps", func(c *gin.Context) {
+		running := provider.RunningModels()
+		models := make([]map[string]interface{}, 0, len(running))
+		for _, m := range running {
+			models = append(models, map[string]interface{}{
+				"name":       m.Model.Name,
+				"model":      m.Model.Model,
+				"size":       m.Model.Size,
+				"digest":     m.Model.Digest,
+				"details":    m.Model.Details,
+				"expires_at": m.ExpiresAt.Format(time.RFC3339),
+				"size_vram":  m.Model.Size,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"models": models})
+	})
+
+	r.POST("/api/show", func(c *gin.Context) {
+		var request map[string]string
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+			return
+		}
+
+		modelName := request["name"]
+		if modelName == "" {
+			modelName = request["model"]
+		}
+		if modelName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Model name is required"})
+			return
+		}
+
+		details, err := provider.GetModelDetails(modelName)
+		if errors.Is(err, errModelNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error getting model details", "Error", err)
+			writeUpstreamError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, details)
+	})
+
+	// there are no weights to copy, so a copy is an alias of the source
+	r.POST("/api/copy", func(c *gin.Context) {
+		var request struct {
+			Source      string `json:"source"`
+			Destination string `json:"destination"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+			return
+		}
+		if request.Source == "" || request.Destination == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "source and destination are required"})
+			return
+		}
+
+		fullModelName, err := provider.GetFullModelName(request.Source)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error getting full model name", "Error", err)
+			writeUpstreamError(c, err)
+			return
+		}
+		if _, ok := provider.modelInfoFor(fullModelName); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", request.Source)})
+			return
+		}
+		modelAliases.Set(request.Destination, fullModelName)
+		if err := modelAliases.save(modelAliasesPath); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error saving model aliases", "Error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Created model alias", "alias", request.Destination, "model", fullModelName)
+		c.Status(http.StatusOK)
+	})
+
+	// only aliases can be deleted, models of the backend stay listed
+	r.DELETE("/api/delete", func(c *gin.Context) {
+		var request struct {
+			Name  string `json:"name"`
+			Model string `json:"model"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+			return
+		}
+		name := request.Name
+		if name == "" {
+			name = request.Model
+		}
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Model name is required"})
+			return
+		}
+
+		if !modelAliases.Remove(name) {
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("alias '%s' not found", name)})
+			return
+		}
+		if err := modelAliases.save(modelAliasesPath); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error saving model aliases", "Error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Deleted model alias", "alias", name)
+		c.Status(http.StatusOK)
+	})
+
+	// models are remote, so pulling only checks that the model exists and
+	// reports the progress of a download that completed instantly
+	r.POST("/api/pull", func(c *gin.Context) {
+		var request struct {
+			Name   string `json:"name"`
+			Model  string `json:"model"`
+			Stream *bool  `json:"stream"`
+		}
+		if err := c.BindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+			return
+		}
+		modelName := request.Model
+		if modelName == "" {
+			modelName = request.Name
+		}
+		if modelName == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Model name is required"})
+			return
+		}
+
+		fullModelName, err := provider.GetFullModelName(modelName)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error getting full model name", "Error", err)
+			writeUpstreamError(c, err)
+			return
+		}
+		_, found := provider.modelInfoFor(fullModelName)
+
+		if request.Stream != nil && !*request.Stream {
+			if !found {
+				c.JSON(http.StatusNotFound, gin.H{"error": "pull model manifest: file does not exist"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"status": "success"})
+			return
+		}
+
+		c.Writer.Header().Set("Content-Type", "application/x-ndjson")
+		w := newNDJSONWriter(c.Writer, config.clientWriteTimeout, 1, 0)
+		defer w.Close()
+
+		frames := []gin.H{{"status": "pulling manifest"}}
+		if found {
+			model := newModel(fullModelName, "")
+			frames = append(frames,
+				gin.H{"status": "pulling " + model.Digest[:12], "digest": "sha256:" + model.Digest, "total": model.Size, "completed": model.Size},
+				gin.H{"status": "verifying sha256 digest"},
+				gin.H{"status": "writing manifest"},
+				gin.H{"status": "success"},
+			)
+		} else {
+			frames = append(frames, gin.H{"error": "pull model manifest: file does not exist"})
+		}
+		for _, frame := range frames {
+			if err := w.WriteJSON(frame); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to write to client", "Error", err)
+				return
+			}
+		}
+	})
+
+	requestRateLimit := rateLimit(ctx, config.rateLimitRPM, config.rateLimitBurst)
+	upstreamLimit := concurrencyLimit(config.maxConcurrentRequests, config.maxQueueWait)
+
+	maxBodyBytes := bodyLimit(config.maxBodyBytes)
+
+	r.POST("/api/chat", requestRateLimit, maxBodyBytes, upstreamLimit, forwardRateLimitHeaders(), func(c *gin.Context) {
+		// all frames of a response share one timestamp, which some clients
+		// order by
+		start := time.Now()
+		createdAt := start.Format(time.RFC3339)
+
+		var request struct {
+			Model     string    `json:"model"`
+			Messages  []Message `json:"messages"`
+			Stream    *bool     `json:"stream"`
+			Options   Options   `json:"options"`
+			KeepAlive *Duration `json:"keep_alive"`
+			User      string    `json:"user"`
+			Think     *bool     `json:"think"`
+		}
+
+		if err := c.ShouldBindJSON(&request); err != nil {
+			if message, ok := bodyTooLarge(err); ok {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": message})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+			return
+		}
+		if request.Model == "" {
+			request.Model = config.defaultModel
+		}
+		if request.Model == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "model is required, set it in the request or configure OLLAMA_DEFAULT_MODEL"})
+			return
+		}
+		api, err := parseUpstreamAPI(request.Options.API)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateLogitBias(request.Options.LogitBias); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateReasoningEffort(request.Options.ReasoningEffort); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Options.User == "" {
+			request.Options.User = request.User
+		}
+		request.Options.Think = request.Think
+		if request.Options.User == "" {
+			request.Options.User = apiKeyUser(c.GetString(contextKeyAPIKey))
+		}
+		messages, err := toChatMessages(request.Messages)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// requests without messages load or unload the model, see below
+		if len(messages) > 0 {
+			if err := validateChatRequest(messages); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		messages = withSystemPrompt(messages)
+		if config.maxPromptChars > 0 {
+			if n := promptChars(messages); n > config.maxPromptChars {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("prompt too long: %d characters exceeds the limit of %d", n, config.maxPromptChars)})
+				return
+			}
+		}
+		// also stands in for the prompt_eval_count of backends reporting no usage
+		promptTokens := estimatePromptTokens(messages, request.Model)
+		if config.maxPromptTokens > 0 && promptTokens > config.maxPromptTokens {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("prompt too long: about %d tokens exceeds the limit of %d", promptTokens, config.maxPromptTokens)})
+			return
+		}
+		keepAlive := request.KeepAlive.Or(defaultKeepAlive)
+		// reasoning is returned as the message's thinking unless disabled
+		showThinking := request.Think == nil || *request.Think
+
+		// Ollama clients load a model by sending no messages, or unload it
+		// with a keep_alive of 0
+		if len(messages) == 0 {
+			fullModelName, err := provider.GetFullModelName(request.Model)
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error getting full model name", "Error", err)
+				writeUpstreamError(c, err)
+				return
+			}
+			doneReason := "unload"
+			if keepAlive != 0 {
+				if _, ok := provider.modelInfoFor(fullModelName); !ok {
+					c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", request.Model)})
+					return
+				}
+				doneReason = "load"
+			}
+			provider.TouchModel(fullModelName, keepAlive)
+
+			c.JSON(http.StatusOK, map[string]interface{}{
+				"model":      fullModelName,
+				"created_at": createdAt,
+				"message": map[string]string{
+					"role":    "assistant",
+					"content": "",
+				},
+				"done_reason": doneReason,
+				"done":        true,
+			})
+			return
+		}
+
+		streamRequested := true
+		if request.Stream != nil {
+			streamRequested = *request.Stream
+		}
+
+		if !streamRequested {
+			fullModelName, err := provider.GetFullModelName(request.Model)
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error getting full model name", "Error", err)
+				writeUpstreamError(c, err)
+				return
+			}
+			if err := checkImageSupport(provider, fullModelName, messages); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			checkNumCtx(c.Request.Context(), provider, fullModelName, request.Options.NumCtx)
+			c.Set(contextKeyModel, fullModelName)
+			provider.TouchModel(fullModelName, keepAlive)
+
+			response, err := routes.For(c.GetString(contextKeyAPIKey), fullModelName).Chat(c.Request.Context(), buildChatRequest(fullModelName, messages, request.Options.forModel(provider, fullModelName), api))
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to get chat response", "Error", err)
+				writeUpstreamError(c, err)
+				return
+			}
+
+			if len(response.Choices) == 0 {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "No response from model"})
+				return
+			}
+			c.Set(contextKeyPromptTokens, response.Usage.PromptTokens)
+			c.Set(contextKeyCompletionTokens, response.Usage.CompletionTokens)
+
+			message := response.Choices[0].Message
+			content := trimTrailing(assembleContent(message.Content, message.Refusal))
+			// a filtered response keeps whatever content was produced, even none
+			filtered := response.Choices[0].FinishReason == openai.FinishReasonContentFilter
+			if filtered {
+				slog.WarnContext(c.Request.Context(), "Response was filtered by the backend", "model", fullModelName)
+			}
+			if content == "" && message.Refusal == "" && len(message.ToolCalls) == 0 && !filtered {
+				switch emptyResponsePolicy {
+				case EmptyResponsePlaceholder:
+					content = emptyResponsePlaceholder
+				case EmptyResponseError:
+					slog.ErrorContext(c.Request.Context(), "Empty response from model", "model", fullModelName)
+					c.JSON(http.StatusBadGateway, gin.H{"error": errEmptyResponse.Error()})
+					return
+				}
+			}
+
+			finishReason, doneReason := finishReasons(response.Choices[0].FinishReason)
+			// some backends report no usage at all
+			if response.Usage.PromptTokens == 0 {
+				response.Usage.PromptTokens = promptTokens
+			}
+			if response.Usage.CompletionTokens == 0 {
+				response.Usage.CompletionTokens = estimateCompletionTokens(content, fullModelName)
+			}
+
+			responseMessage := map[string]string{
+				"role":    "assistant",
+				"content": content,
+			}
+			if showThinking && response.Reasoning != "" {
+				responseMessage["thinking"] = response.Reasoning
+			}
+
+			ollamaResponse := map[string]interface{}{
+				"model":         fullModelName,
+				"created_at":    createdAt,
+				"message":       responseMessage,
+				"done":          true,
+				"finish_reason": finishReason,
+				"done_reason":   doneReason,
+			}
+			maps.Copy(ollamaResponse, usageStats(response.Usage, start, time.Time{}))
+
+			c.JSON(http.StatusOK, ollamaResponse)
+			return
+		}
+
+		slog.InfoContext(c.Request.Context(), "Requested model", "model", request.Model)
+		fullModelName, err := provider.GetFullModelName(request.Model)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error getting full model name", "Error", err, "model", request.Model)
+			writeUpstreamError(c, err)
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Using model", "fullModelName", fullModelName)
+		if err := checkImageSupport(provider, fullModelName, messages); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		checkNumCtx(c.Request.Context(), provider, fullModelName, request.Options.NumCtx)
+		c.Set(contextKeyModel, fullModelName)
+		provider.TouchModel(fullModelName, keepAlive)
+
+		// End the upstream stream early when the server shuts down, so the
+		// client still receives a final done frame within the grace period.
+		streamCtx, cancelStream := context.WithCancel(c.Request.Context())
+		defer cancelStream()
+		stopOnShutdown := context.AfterFunc(ctx, cancelStream)
+		defer stopOnShutdown()
+
+		stream, err := routes.For(c.GetString(contextKeyAPIKey), fullModelName).ChatStream(streamCtx, buildChatRequest(fullModelName, messages, request.Options.forModel(provider, fullModelName), api))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to create stream", "Error", err)
+			writeUpstreamError(c, err)
+			return
+		}
+		defer stream.Close()
+
+		c.Writer.Header().Set("Content-Type", "application/x-ndjson")
+		c.Writer.Header().Set("Cache-Control", "no-cache")
+		c.Writer.Header().Set("Connection", "keep-alive")
+		c.Writer.Header().Set("Trailer", streamErrorTrailer)
+
+		w := newNDJSONWriter(c.Writer, config.clientWriteTimeout, config.streamFlushFrames, config.streamFlushInterval)
+		defer w.Close()
+
+		if config.streamPrefix != "" {
+			if err := w.WriteLine([]byte(config.streamPrefix)); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to write to client, aborting stream", "Error", err)
+				return
+			}
+		}
+		// set when the stream failed before any frame, and the error was
+		// sent as a plain response instead
+		var failedBeforeStream bool
+		defer func() {
+			if config.streamSuffix != "" && !failedBeforeStream {
+				if err := w.WriteLine([]byte(config.streamSuffix)); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Failed to write to client", "Error", err)
+				}
+			}
+		}()
+
+		var lastFinishReason openai.FinishReason
+		var usage openai.Usage
+		var fullContent, fullThinking strings.Builder
+		// all content, to estimate its tokens if the backend reports no usage
+		var generated strings.Builder
+		var trailing trailingWhitespace
+		empty := true
+		// the backend usually only sends the role with the first delta
+		role := openai.ChatMessageRoleAssistant
+		var firstToken time.Time
+
+		for {
+			response, err := recvChunk(stream)
+			if errors.Is(err, io.EOF) {
+
+				break
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					slog.InfoContext(c.Request.Context(), "Server shutting down, ending stream", "model", fullModelName)
+					break
+				}
+				slog.ErrorContext(c.Request.Context(), "Backend stream error", "Error", err)
+				// e.g. a rate limit reported in the first chunk: nothing was
+				// streamed yet, so the client can still get its status
+				if !c.Writer.Written() {
+					failedBeforeStream = true
+					c.Writer.Header().Del("Content-Type")
+					c.Writer.Header().Del("Trailer")
+					writeUpstreamError(c, err)
+					return
+				}
+				_, message := upstreamError(err)
+				c.Writer.Header().Set(streamErrorTrailer, message)
+				if err := w.WriteJSON(streamErrorFrame(fullModelName, createdAt, role, message)); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Failed to write to client", "Error", err)
+				}
+				return
+			}
+
+			if response.Usage != nil {
+				usage = *response.Usage
+			}
+			// e.g. the usage-only final chunk
+			choice, ok := firstChoice(response.Choices)
+			if !ok {
+				continue
+			}
+			if firstToken.IsZero() {
+				firstToken = time.Now()
+			}
+			if choice.FinishReason != "" {
+				lastFinishReason = choice.FinishReason
+			}
+
+			delta := choice.Delta
+			if delta.Role != "" {
+				role = delta.Role
+			}
+			content := trailing.next(assembleContent(delta.Content, delta.Refusal))
+			if content != "" || delta.Refusal != "" || len(delta.ToolCalls) > 0 {
+				empty = false
+			}
+			generated.WriteString(content)
+			if config.streamFinalContent {
+				fullContent.WriteString(content)
+			}
+			if content != "" {
+				streamedTokensTotal.WithLabelValues(c.FullPath(), modelLabel(fullModelName)).Inc()
+			}
+
+			frameMessage := map[string]string{
+				"role":    role,
+				"content": content,
+			}
+			if showThinking && response.Reasoning != "" {
+				frameMessage["thinking"] = response.Reasoning
+				if config.streamFinalContent {
+					fullThinking.WriteString(response.Reasoning)
+				}
+			}
+
+			responseJSON := map[string]interface{}{
+				"model":      fullModelName,
+				"created_at": createdAt,
+				"message":    frameMessage,
+				"done":       false,
+			}
+
+			if err := w.WriteJSON(responseJSON); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to write to client, aborting stream", "Error", err)
+				return
+			}
+		}
+
+		filtered := lastFinishReason == openai.FinishReasonContentFilter
+		if filtered {
+			slog.WarnContext(c.Request.Context(), "Response was filtered by the backend", "model", fullModelName)
+		}
+		if empty && !filtered {
+			switch emptyResponsePolicy {
+			case EmptyResponsePlaceholder:
+				if config.streamFinalContent {
+					fullContent.WriteString(emptyResponsePlaceholder)
+				}
+				placeholderResponse := map[string]interface{}{
+					"model":      fullModelName,
+					"created_at": createdAt,
+					"message": map[string]string{
+						"role":    role,
+						"content": emptyResponsePlaceholder,
+					},
+					"done": false,
+				}
+				if err := w.WriteJSON(placeholderResponse); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Failed to write to client, aborting stream", "Error", err)
+					return
+				}
+			case EmptyResponseError:
+				slog.ErrorContext(c.Request.Context(), "Empty response from model", "model", fullModelName)
+				c.Writer.Header().Set(streamErrorTrailer, errEmptyResponse.Error())
+				if err := w.WriteJSON(streamErrorFrame(fullModelName, createdAt, role, errEmptyResponse.Error())); err != nil {
+					slog.ErrorContext(c.Request.Context(), "Failed to write to client", "Error", err)
+				}
+				return
+			}
+		}
+
+		finishReason, doneReason := finishReasons(lastFinishReason)
+		if usage.TotalTokens > 0 {
+			c.Set(contextKeyPromptTokens, usage.PromptTokens)
+			c.Set(contextKeyCompletionTokens, usage.CompletionTokens)
+		}
+		// some backends report no usage at all
+		if usage.PromptTokens == 0 {
+			usage.PromptTokens = promptTokens
+		}
+		if usage.CompletionTokens == 0 {
+			usage.CompletionTokens = estimateCompletionTokens(generated.String(), fullModelName)
+		}
+
+		finalMessage := map[string]string{
+			"role":    role,
+			"content": fullContent.String(),
+		}
+		if fullThinking.Len() > 0 {
+			finalMessage["thinking"] = fullThinking.String()
+		}
+
+		finalResponse := map[string]interface{}{
+			"model":         fullModelName,
+			"created_at":    createdAt,
+			"message":       finalMessage,
+			"done":          true,
+			"finish_reason": finishReason,
+			"done_reason":   doneReason,
+		}
+		maps.Copy(finalResponse, usageStats(usage, start, firstToken))
+
+		if err := w.WriteJSON(finalResponse); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to write final response", "Error", err)
+		}
+	})
+
+	// a drop-in OpenAI chat completions server, returning every choice of
+	// requests with n > 1
+	r.POST("/v1/chat/completions", requestRateLimit, maxBodyBytes, upstreamLimit, forwardRateLimitHeaders(), func(c *gin.Context) {
+		var request struct {
+			Model               string                         `json:"model"`
+			Messages            []openai.ChatCompletionMessage `json:"messages"`
+			MaxTokens           int                            `json:"max_tokens"`
+			MaxCompletionTokens int                            `json:"max_completion_tokens"`
+			Temperature         *float32                       `json:"temperature"`
+			TopP                *float32                       `json:"top_p"`
+			N                   int                            `json:"n"`
+			Stream              bool                           `json:"stream"`
+			User                string                         `json:"user"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			if message, ok := bodyTooLarge(err); ok {
+				c.JSON(http.StatusRequestEntityTooLarge, openAIError(http.StatusRequestEntityTooLarge, message))
+				return
+			}
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, "Invalid JSON payload"))
+			return
+		}
+		if request.Model == "" {
+			request.Model = config.defaultModel
+		}
+		if request.Model == "" {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, "model is required"))
+			return
+		}
+		if request.N < 0 {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, "n must be positive"))
+			return
+		}
+		if err := validateChatRequest(request.Messages); err != nil {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		fullModelName, err := provider.GetFullModelName(request.Model)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error getting full model name", "Error", err)
+			writeOpenAIUpstreamError(c, err)
+			return
+		}
+		c.Set(contextKeyModel, fullModelName)
+
+		options := Options{NumPredict: max(request.MaxTokens, request.MaxCompletionTokens), User: request.User}
+		if options.User == "" {
+			options.User = apiKeyUser(c.GetString(contextKeyAPIKey))
+		}
+		chatRequest := buildChatRequest(fullModelName, request.Messages, options.forModel(provider, fullModelName), UpstreamAPIChat)
+		chatRequest.N = request.N
+		if request.Temperature != nil {
+			chatRequest.Temperature = *request.Temperature
+		}
+		if request.TopP != nil {
+			chatRequest.TopP = *request.TopP
+		}
+		upstream := routes.For(c.GetString(contextKeyAPIKey), fullModelName)
+
+		if !request.Stream {
+			response, err := upstream.Chat(c.Request.Context(), chatRequest)
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to get chat response", "Error", err)
+				writeOpenAIUpstreamError(c, err)
+				return
+			}
+			c.Set(contextKeyPromptTokens, response.Usage.PromptTokens)
+			c.Set(contextKeyCompletionTokens, response.Usage.CompletionTokens)
+			response.Model = request.Model
+			c.JSON(http.StatusOK, response.ChatCompletionResponse)
+			return
+		}
+
+		streamCtx, cancelStream := context.WithCancel(c.Request.Context())
+		defer cancelStream()
+		stopOnShutdown := context.AfterFunc(ctx, cancelStream)
+		defer stopOnShutdown()
+
+		stream, err := upstream.ChatStream(streamCtx, chatRequest)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to create stream", "Error", err)
+			writeOpenAIUpstreamError(c, err)
+			return
+		}
+		defer stream.Close()
+
+		c.Writer.Header().Set("Content-Type", "text/event-stream")
+		c.Writer.Header().Set("Cache-Control", "no-cache")
+		c.Writer.Header().Set("Connection", "keep-alive")
+		w := newNDJSONWriter(c.Writer, config.clientWriteTimeout, config.streamFlushFrames, config.streamFlushInterval)
+		defer w.Close()
+		stopKeepAlive := w.KeepAlive(config.sseKeepAliveInterval, []byte(": keep-alive\n"))
+		defer stopKeepAlive()
+
+		for {
+			response, err := recvChunk(stream)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Backend stream error", "Error", err)
+				_, message := upstreamError(err)
+				writeSSE(w, openAIError(http.StatusBadGateway, message))
+				return
+			}
+			if response.Usage != nil {
+				c.Set(contextKeyPromptTokens, response.Usage.PromptTokens)
+				c.Set(contextKeyCompletionTokens, response.Usage.CompletionTokens)
+			}
+			for _, choice := range response.Choices {
+				if choice.Delta.Content != "" {
+					streamedTokensTotal.WithLabelValues(c.FullPath(), modelLabel(fullModelName)).Inc()
+				}
+			}
+			// every choice is passed on, each delta keeping its index
+			response.Model = request.Model
+			if err := writeSSE(w, response.ChatCompletionStreamResponse); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to write to client, aborting stream", "Error", err)
+				return
+			}
+		}
+		if err := w.WriteLine([]byte("data: [DONE]\n")); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to write to client", "Error", err)
+		}
+	})
+
+	// the legacy prompt-based API, served by a single chat completion
+	r.POST("/v1/completions", requestRateLimit, maxBodyBytes, upstreamLimit, forwardRateLimitHeaders(), func(c *gin.Context) {
+		var request struct {
+			Model       string          `json:"model"`
+			Prompt      json.RawMessage `json:"prompt"`
+			MaxTokens   int             `json:"max_tokens"`
+			Temperature *float32        `json:"temperature"`
+			Stream      bool            `json:"stream"`
+			User        string          `json:"user"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			if message, ok := bodyTooLarge(err); ok {
+				c.JSON(http.StatusRequestEntityTooLarge, openAIError(http.StatusRequestEntityTooLarge, message))
+				return
+			}
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, "Invalid JSON payload"))
+			return
+		}
+		if request.Model == "" {
+			request.Model = config.defaultModel
+		}
+		if request.Model == "" {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, "model is required"))
+			return
+		}
+		prompt, err := legacyPrompt(request.Prompt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		fullModelName, err := provider.GetFullModelName(request.Model)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error getting full model name", "Error", err)
+			writeOpenAIUpstreamError(c, err)
+			return
+		}
+		c.Set(contextKeyModel, fullModelName)
+
+		options := Options{NumPredict: request.MaxTokens, User: request.User}
+		if options.User == "" {
+			options.User = apiKeyUser(c.GetString(contextKeyAPIKey))
+		}
+		messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prompt}}
+		if err := validateChatRequest(messages); err != nil {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, err.Error()))
+			return
+		}
+		chatRequest := buildChatRequest(fullModelName, messages, options.forModel(provider, fullModelName), UpstreamAPIChat)
+		if request.Temperature != nil {
+			chatRequest.Temperature = *request.Temperature
+		}
+		upstream := routes.For(c.GetString(contextKeyAPIKey), fullModelName)
+
+		if !request.Stream {
+			response, err := upstream.Chat(c.Request.Context(), chatRequest)
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to get chat response", "Error", err)
+				writeOpenAIUpstreamError(c, err)
+				return
+			}
+			c.Set(contextKeyPromptTokens, response.Usage.PromptTokens)
+			c.Set(contextKeyCompletionTokens, response.Usage.CompletionTokens)
+			c.JSON(http.StatusOK, toLegacyCompletion(response.ChatCompletionResponse, request.Model))
+			return
+		}
+
+		streamCtx, cancelStream := context.WithCancel(c.Request.Context())
+		defer cancelStream()
+		stopOnShutdown := context.AfterFunc(ctx, cancelStream)
+		defer stopOnShutdown()
+
+		stream, err := upstream.ChatStream(streamCtx, chatRequest)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to create stream", "Error", err)
+			writeOpenAIUpstreamError(c, err)
+			return
+		}
+		defer stream.Close()
+
+		c.Writer.Header().Set("Content-Type", "text/event-stream")
+		c.Writer.Header().Set("Cache-Control", "no-cache")
+		c.Writer.Header().Set("Connection", "keep-alive")
+		w := newNDJSONWriter(c.Writer, config.clientWriteTimeout, config.streamFlushFrames, config.streamFlushInterval)
+		defer w.Close()
+		stopKeepAlive := w.KeepAlive(config.sseKeepAliveInterval, []byte(": keep-alive\n"))
+		defer stopKeepAlive()
+
+		for {
+			response, err := recvChunk(stream)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Backend stream error", "Error", err)
+				_, message := upstreamError(err)
+				writeSSE(w, openAIError(http.StatusBadGateway, message))
+				return
+			}
+			if response.Usage != nil {
+				c.Set(contextKeyPromptTokens, response.Usage.PromptTokens)
+				c.Set(contextKeyCompletionTokens, response.Usage.CompletionTokens)
+			}
+			choice, ok := firstChoice(response.Choices)
+			if !ok {
+				continue
+			}
+			if choice.Delta.Content != "" {
+				streamedTokensTotal.WithLabelValues(c.FullPath(), modelLabel(fullModelName)).Inc()
+			}
+			chunk := legacyCompletion{
+				ID:      response.ID,
+				Object:  "text_completion",
+				Created: response.Created,
+				Model:   request.Model,
+				Choices: []legacyCompletionChoice{newLegacyChoice(0, choice.Delta.Content, choice.FinishReason)},
+			}
+			if err := writeSSE(w, chunk); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to write to client, aborting stream", "Error", err)
+				return
+			}
+		}
+		if err := w.WriteLine([]byte("data: [DONE]\n")); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to write to client", "Error", err)
+		}
+	})
+
+	// a drop-in OpenAI embeddings server
+	r.POST("/v1/embeddings", requestRateLimit, maxBodyBytes, upstreamLimit, forwardRateLimitHeaders(), func(c *gin.Context) {
+		var request struct {
+			Model string          `json:"model"`
+			Input json.RawMessage `json:"input"`
+			User  string          `json:"user"`
+			// EncodingFormat is "float" (default) or "base64"
+			EncodingFormat string `json:"encoding_format"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			if message, ok := bodyTooLarge(err); ok {
+				c.JSON(http.StatusRequestEntityTooLarge, openAIError(http.StatusRequestEntityTooLarge, message))
+				return
+			}
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, "Invalid JSON payload"))
+			return
+		}
+		if request.Model == "" {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, "model is required"))
+			return
+		}
+		if request.EncodingFormat != "" && request.EncodingFormat != "float" && request.EncodingFormat != "base64" {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, "encoding_format must be float or base64"))
+			return
+		}
+		inputs, err := embeddingInputs(request.Input)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		fullModelName, err := provider.GetFullModelName(request.Model)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error getting full model name", "Error", err)
+			writeOpenAIUpstreamError(c, err)
+			return
+		}
+		shortName := fullModelName[strings.LastIndex(fullModelName, "/")+1:]
+		if !embeddingsFilter.Load().Allows(request.Model, shortName, fullModelName) {
+			c.JSON(http.StatusBadRequest, openAIError(http.StatusBadRequest, fmt.Sprintf("model %q is not available for embeddings", request.Model)))
+			return
+		}
+		c.Set(contextKeyModel, fullModelName)
+		user := request.User
+		if user == "" {
+			user = apiKeyUser(c.GetString(contextKeyAPIKey))
+		}
+
+		response, err := routes.For(c.GetString(contextKeyAPIKey), fullModelName).Embeddings(c.Request.Context(), fullModelName, inputs, user)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to get embeddings", "Error", err)
+			writeOpenAIUpstreamError(c, err)
+			return
+		}
+		c.Set(contextKeyPromptTokens, response.Usage.PromptTokens)
+
+		data := make([]gin.H, 0, len(response.Data))
+		for i, embedding := range response.Data {
+			var vector any = embedding.Embedding
+			if request.EncodingFormat == "base64" {
+				vector = encodeEmbedding(embedding.Embedding)
+			}
+			data = append(data, gin.H{"object": "embedding", "embedding": vector, "index": i})
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"object": "list",
+			"data":   data,
+			"model":  request.Model,
+			"usage": gin.H{
+				"prompt_tokens": response.Usage.PromptTokens,
+				"total_tokens":  response.Usage.TotalTokens,
+			},
+		})
+	})
+
+	return r
+}
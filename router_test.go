@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestRouter serves the proxy API in front of a fake upstream answering
+// chat requests with handler, without a models filter.
+func newTestRouter(t *testing.T, config routerConfig, handler http.HandlerFunc) *gin.Engine {
+	t.Helper()
+	withTestFilter(t)
+	provider, _ := newTestProvider(t, handler)
+	return newRouter(context.Background(), config, provider, &ProviderRoutes{fallback: provider})
+}
+
+// writeTestStream answers a streamed chat completion request with chunks,
+// each the JSON of a chat.completion.chunk.
+func writeTestStream(w http.ResponseWriter, chunks ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, chunk := range chunks {
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// testChunk is a chat.completion.chunk with the given choices and extra fields.
+func testChunk(choices string, extra string) string {
+	chunk := `{"id":"chatcmpl-test","object":"chat.completion.chunk","created":1,"model":"openai/gpt-4o","choices":` + choices
+	if extra != "" {
+		chunk += "," + extra
+	}
+	return chunk + "}"
+}
+
+// parseNDJSON decodes the frames of an NDJSON stream.
+func parseNDJSON(t *testing.T, body string) []map[string]any {
+	t.Helper()
+	var frames []map[string]any
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		var frame map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			t.Fatalf("invalid NDJSON frame %q: %v", scanner.Text(), err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func serveTestRequest(router http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return recorder
+}
+
+// The final chunk of backends reporting usage has no choices.
+func TestChatStreamUsageOnlyChunk(t *testing.T) {
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		writeTestStream(w,
+			testChunk(`[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]`, ""),
+			testChunk(`[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]`, ""),
+			testChunk(`[]`, `"usage":{"prompt_tokens":7,"completion_tokens":2,"total_tokens":9}`),
+		)
+	})
+
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	frames := parseNDJSON(t, recorder.Body.String())
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want 3:\n%s", len(frames), recorder.Body)
+	}
+	for i, want := range []string{"Hel", "lo"} {
+		message := frames[i]["message"].(map[string]any)
+		if frames[i]["done"] != false || message["content"] != want {
+			t.Errorf("frame %d = %v, want the delta %q", i, frames[i], want)
+		}
+	}
+	final := frames[2]
+	if final["done"] != true || final["done_reason"] != "stop" {
+		t.Errorf("final frame = %v, want done with reason stop", final)
+	}
+	if final["prompt_eval_count"] != float64(7) || final["eval_count"] != float64(2) {
+		t.Errorf("final frame counts = %v/%v, want the reported usage 7/2", final["prompt_eval_count"], final["eval_count"])
+	}
+}
+
+func TestCompletionsStreamUsageOnlyChunk(t *testing.T) {
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		writeTestStream(w,
+			testChunk(`[{"index":0,"delta":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]`, ""),
+			testChunk(`[]`, `"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}`),
+		)
+	})
+
+	recorder := serveTestRequest(router, http.MethodPost, "/v1/completions", `{"model":"gpt-4o","prompt":"Say hi","stream":true}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	events := parseSSE(t, recorder.Body.String())
+	if len(events) != 2 || events[1] != "[DONE]" {
+		t.Fatalf("events = %q, want one completion and [DONE]", events)
+	}
+	var chunk legacyCompletion
+	if err := json.Unmarshal([]byte(events[0]), &chunk); err != nil {
+		t.Fatal(err)
+	}
+	if len(chunk.Choices) != 1 || chunk.Choices[0].Text != "Hi" {
+		t.Errorf("completion = %+v, want the text Hi", chunk)
+	}
+}
+
+func TestChatStreamPrefixSuffix(t *testing.T) {
+	config := routerConfig{streamPrefix: ")]}'", streamSuffix: "[END]", streamFlushFrames: 2}
+	router := newTestRouter(t, config, func(w http.ResponseWriter, r *http.Request) {
+		writeTestStream(w,
+			testChunk(`[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]`, ""),
+			testChunk(`[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]`, ""),
+		)
+	})
+
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	lines := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n"), "\n")
+	if len(lines) != 5 || lines[0] != ")]}'" || lines[len(lines)-1] != "[END]" {
+		t.Fatalf("stream is not bracketed by the prefix and suffix:\n%s", recorder.Body)
+	}
+	frames := parseNDJSON(t, strings.Join(lines[1:len(lines)-1], "\n"))
+	if frames[0]["message"].(map[string]any)["content"] != "Hel" || frames[2]["done"] != true {
+		t.Errorf("frames = %v, want the deltas and a final frame", frames)
+	}
+}
+
+// Requests without messages load a model, or unload it with a keep_alive of 0.
+func TestChatLoadUnload(t *testing.T) {
+	router := newTestRouter(t, routerConfig{}, nil)
+
+	tests := []struct {
+		body           string
+		wantStatus     int
+		wantDoneReason string
+	}{
+		{`{"model":"gpt-4o","messages":[]}`, http.StatusOK, "load"},
+		{`{"model":"gpt-4o"}`, http.StatusOK, "load"},
+		{`{"model":"gpt-4o","messages":[],"keep_alive":0}`, http.StatusOK, "unload"},
+		{`{"model":"no-such-model","messages":[]}`, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", tt.body)
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.body, recorder.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantDoneReason == "" {
+			continue
+		}
+		var response map[string]any
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response["done"] != true || response["done_reason"] != tt.wantDoneReason || response["model"] != "openai/gpt-4o" {
+			t.Errorf("%s: response = %v, want done with reason %s", tt.body, response, tt.wantDoneReason)
+		}
+	}
+}
+
+func TestChatStreamFinalContent(t *testing.T) {
+	upstream := func(w http.ResponseWriter, r *http.Request) {
+		writeTestStream(w,
+			testChunk(`[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]`, ""),
+			testChunk(`[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]`, ""),
+		)
+	}
+	for _, enabled := range []bool{false, true} {
+		router := newTestRouter(t, routerConfig{streamFinalContent: enabled}, upstream)
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`)
+		frames := parseNDJSON(t, recorder.Body.String())
+		if len(frames) != 3 {
+			t.Fatalf("got %d frames, want 3:\n%s", len(frames), recorder.Body)
+		}
+		want := ""
+		if enabled {
+			want = "Hello"
+		}
+		final := frames[2]
+		if final["done"] != true || final["message"].(map[string]any)["content"] != want {
+			t.Errorf("streamFinalContent %v: final frame = %v, want the content %q", enabled, final, want)
+		}
+		if delta := frames[1]["message"].(map[string]any)["content"]; delta != "lo" {
+			t.Errorf("streamFinalContent %v: delta = %q, want lo", enabled, delta)
+		}
+	}
+}
+
+// runningModels returns the names of the models listed by /api/ps.
+func runningModels(t *testing.T, router http.Handler) []string {
+	t.Helper()
+	var response struct {
+		Models []struct {
+			Model string `json:"model"`
+		} `json:"models"`
+	}
+	recorder := serveTestRequest(router, http.MethodGet, "/api/ps", "")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	var models []string
+	for _, m := range response.Models {
+		models = append(models, m.Model)
+	}
+	return models
+}
+
+func TestChatUnloadRemovesRunningModel(t *testing.T) {
+	router := newTestRouter(t, routerConfig{}, nil)
+
+	serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o"}`)
+	serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"claude-3.5-sonnet"}`)
+	if got := runningModels(t, router); len(got) != 2 {
+		t.Fatalf("running models = %q, want both loaded models", got)
+	}
+
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","messages":[],"keep_alive":0}`)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"done_reason":"unload"`) {
+		t.Fatalf("unload: status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	if got := runningModels(t, router); len(got) != 1 || got[0] != "claude-3.5-sonnet" {
+		t.Errorf("running models = %q, want only claude-3.5-sonnet", got)
+	}
+}
+
+// tagsModels returns the models listed by /api/tags.
+func tagsModels(t *testing.T, router http.Handler) []map[string]any {
+	t.Helper()
+	recorder := serveTestRequest(router, http.MethodGet, "/api/tags", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	var response struct {
+		Models []map[string]any `json:"models"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Models
+}
+
+// SYSTEM_PROMPT counts towards MAX_PROMPT_TOKENS, whichever mode adds it.
+func TestChatMaxPromptTokensWithSystemPrompt(t *testing.T) {
+	var calls int
+	router := newTestRouter(t, routerConfig{maxPromptTokens: 100}, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeTestCompletion(w, "Hi")
+	})
+	previousPrompt, previousMode := systemPrompt, systemPromptMode
+	t.Cleanup(func() { systemPrompt, systemPromptMode = previousPrompt, previousMode })
+
+	body := `{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"Hi"}]}`
+	if recorder := serveTestRequest(router, http.MethodPost, "/api/chat", body); recorder.Code != http.StatusOK {
+		t.Fatalf("without a system prompt: status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	systemPrompt = strings.Repeat("Always answer in full sentences. ", 50)
+	for _, mode := range []SystemPromptMode{SystemPromptPrepend, SystemPromptMerge} {
+		systemPromptMode = mode
+		calls = 0
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", body)
+		if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "prompt too long") {
+			t.Errorf("mode %s: status = %d, body %s, want 400 for the long system prompt", mode, recorder.Code, recorder.Body)
+		}
+		if calls != 0 {
+			t.Errorf("mode %s: the request reached the backend", mode)
+		}
+	}
+}
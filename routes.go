@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// ProviderRoutes selects the upstream provider for a resolved model, so that
+// models matching a pattern can use their own API key and base URL, and
+// clients with a mapped proxy API key their own upstream key.
+type ProviderRoutes struct {
+	prefixes []prefixRoute
+	routes   []providerRoute
+	clients  map[string]*OpenrouterProvider // proxy API key -> provider
+	fallback *OpenrouterProvider
+}
+
+type providerRoute struct {
+	pattern  matcher
+	provider *OpenrouterProvider
+}
+
+// prefixRoute sends models namespaced with a prefix, e.g. "local/llama3", to
+// a separate upstream, which receives the model name without it.
+type prefixRoute struct {
+	prefix   string
+	provider *OpenrouterProvider
+}
+
+type providerRouteConfig struct {
+	Models  string `json:"models"`
+	APIKey  string `json:"api_key"`
+	BaseURL string `json:"base_url"`
+}
+
+// parseProviderRoutes reads a JSON array of routes, e.g.
+// [{"models": "anthropic/*", "api_key": "...", "base_url": "..."}]. Routes are
+// tried in order; a missing api_key or base_url is taken from the fallback.
+func parseProviderRoutes(value string, fallback *OpenrouterProvider, baseUrl string, apiKey string) (*ProviderRoutes, error) {
+	routes := &ProviderRoutes{fallback: fallback}
+	if value == "" {
+		return routes, nil
+	}
+
+	var configs []providerRouteConfig
+	if err := json.Unmarshal([]byte(value), &configs); err != nil {
+		return nil, err
+	}
+
+	for _, config := range configs {
+		routeBaseUrl, routeApiKey := config.BaseURL, config.APIKey
+		if routeBaseUrl == "" {
+			routeBaseUrl = baseUrl
+		}
+		if routeApiKey == "" {
+			routeApiKey = apiKey
+		}
+
+		route := providerRoute{
+			pattern:  matcher{exact: make(map[string]struct{})},
+			provider: NewOpenrouterProvider(routeBaseUrl, routeApiKey),
+		}
+		route.pattern.add(config.Models)
+		routes.routes = append(routes.routes, route)
+	}
+	return routes, nil
+}
+
+// addClients registers a provider for each proxy API key mapped to an
+// upstream key.
+func (r *ProviderRoutes) addClients(clientKeys map[string]string, baseUrl string) {
+	if len(clientKeys) == 0 {
+		return
+	}
+	r.clients = make(map[string]*OpenrouterProvider, len(clientKeys))
+	for proxyKey, upstreamKey := range clientKeys {
+		r.clients[proxyKey] = NewOpenrouterProvider(baseUrl, upstreamKey)
+	}
+}
+
+type upstreamProviderConfig struct {
+	BaseURL string `json:"base_url"`
+	APIKey  string `json:"api_key"`
+}
+
+// addPrefixes registers the upstreams of the UPSTREAM_PROVIDERS JSON object,
+// which maps model prefixes to base URLs and keys, e.g.
+// {"local": {"base_url": "http://localhost:8000/v1"}}. The default API key is
+// deliberately not sent to these upstreams.
+func (r *ProviderRoutes) addPrefixes(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	var configs map[string]upstreamProviderConfig
+	if err := json.Unmarshal([]byte(value), &configs); err != nil {
+		return err
+	}
+
+	for prefix, config := range configs {
+		if config.BaseURL == "" {
+			return fmt.Errorf("provider %q has no base_url", prefix)
+		}
+		provider := NewOpenrouterProvider(config.BaseURL, config.APIKey)
+		provider.modelPrefix = strings.TrimSuffix(prefix, "/") + "/"
+		r.prefixes = append(r.prefixes, prefixRoute{prefix: provider.modelPrefix, provider: provider})
+	}
+	// longest prefix first, so nested namespaces resolve to the closest one
+	sort.Slice(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i].prefix) > len(r.prefixes[j].prefix)
+	})
+	return nil
+}
+
+// modelSources returns a source per prefixed upstream, listing its models
+// under their namespaced IDs.
+func (r *ProviderRoutes) modelSources() []ModelSource {
+	sources := make([]ModelSource, 0, len(r.prefixes))
+	for _, route := range r.prefixes {
+		sources = append(sources, prefixedModelSource{prefix: route.prefix, provider: route.provider})
+	}
+	return sources
+}
+
+// prefixedModelSource lists the models of a prefixed upstream. An upstream
+// that can't be reached is skipped rather than failing the whole list.
+type prefixedModelSource struct {
+	prefix   string
+	provider *OpenrouterProvider
+}
+
+func (s prefixedModelSource) ListModels(ctx context.Context) ([]upstreamModel, error) {
+	models, err := s.provider.modelSource.ListModels(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Error listing models of provider, skipping it", "Error", err, "prefix", s.prefix)
+		return nil, nil
+	}
+	for i := range models {
+		models[i].ID = s.prefix + models[i].ID
+		models[i].namespace = s.prefix
+	}
+	return models, nil
+}
+
+// For returns the provider to use for the given full model name, requested
+// with the given proxy API key (empty without auth). Prefixed upstreams take
+// precedence over model routes, which take precedence over client keys, and
+// unmapped clients use the fallback.
+func (r *ProviderRoutes) For(apiKey string, fullModelName string) *OpenrouterProvider {
+	for _, route := range r.prefixes {
+		if strings.HasPrefix(fullModelName, route.prefix) {
+			return route.provider
+		}
+	}
+	for _, route := range r.routes {
+		if route.pattern.match(fullModelName) {
+			return route.provider
+		}
+	}
+	if provider, ok := r.clients[apiKey]; ok {
+		return provider
+	}
+	return r.fallback
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// Models matching a route are sent to its upstream with its key, others to
+// the default upstream with the default key.
+func TestProviderRoutesKeys(t *testing.T) {
+	received := make(map[string]string) // upstream -> Authorization header
+	upstream := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			received[name] = r.Header.Get("Authorization")
+			writeTestCompletion(w, "Hi from "+name)
+		}
+	}
+	defaultServer, _ := newTestUpstream(t, upstream("default"))
+	anthropicServer, _ := newTestUpstream(t, upstream("anthropic"))
+
+	provider := NewOpenrouterProvider(defaultServer.URL+"/v1", "sk-default")
+	routes, err := parseProviderRoutes(`[{"models":"anthropic/*","api_key":"sk-anthropic","base_url":"`+anthropicServer.URL+`/v1"}]`, provider, defaultServer.URL+"/v1", "sk-default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter(context.Background(), routerConfig{}, provider, routes)
+
+	for _, model := range []string{"claude-3.5-sonnet", "gpt-4o"} {
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"`+model+`","stream":false,"messages":[{"role":"user","content":"Hi"}]}`)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, body %s", model, recorder.Code, recorder.Body)
+		}
+	}
+	want := map[string]string{"default": "Bearer sk-default", "anthropic": "Bearer sk-anthropic"}
+	for name, auth := range want {
+		if received[name] != auth {
+			t.Errorf("%s upstream got Authorization %q, want %q", name, received[name], auth)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+var stopSequences *StopSequences
+
+// StopSequences maps model patterns (e.g. "qwen/*") to default stop sequences
+// that are merged with the stops sent by the client.
+type StopSequences struct {
+	rules []stopRule
+}
+
+type stopRule struct {
+	pattern matcher
+	stops   []string
+}
+
+// parseStopSequences reads a JSON object of model pattern to stop sequences,
+// e.g. {"qwen/*": ["<|im_end|>"]}. An empty value yields no defaults.
+func parseStopSequences(value string) (*StopSequences, error) {
+	sequences := &StopSequences{}
+	if value == "" {
+		return sequences, nil
+	}
+
+	var raw map[string][]string
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, err
+	}
+
+	patterns := make([]string, 0, len(raw))
+	for pattern := range raw {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+
+	for _, pattern := range patterns {
+		rule := stopRule{
+			pattern: matcher{exact: make(map[string]struct{})},
+			stops:   raw[pattern],
+		}
+		rule.pattern.add(pattern)
+		sequences.rules = append(sequences.rules, rule)
+	}
+	return sequences, nil
+}
+
+// Merge returns the client stops followed by the defaults of every rule
+// matching the model, without duplicates.
+func (s *StopSequences) Merge(model string, stops []string) []string {
+	if s == nil || len(s.rules) == 0 {
+		return stops
+	}
+
+	seen := make(map[string]struct{}, len(stops))
+	merged := make([]string, 0, len(stops))
+	add := func(stop string) {
+		if _, ok := seen[stop]; ok {
+			return
+		}
+		seen[stop] = struct{}{}
+		merged = append(merged, stop)
+	}
+
+	for _, stop := range stops {
+		add(stop)
+	}
+	for _, rule := range s.rules {
+		if rule.pattern.match(model) {
+			for _, stop := range rule.stops {
+				add(stop)
+			}
+		}
+	}
+
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStopSequencesMerge(t *testing.T) {
+	sequences, err := parseStopSequences(`{"qwen/*": ["<|im_end|>", "<|endoftext|>"], "qwen/qwen-2.5-72b-instruct": ["<|im_start|>"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		model string
+		stops []string
+		want  []string
+	}{
+		{"qwen/qwen-2.5-72b-instruct", nil, []string{"<|im_end|>", "<|endoftext|>", "<|im_start|>"}},
+		{"qwen/qwen-2.5-7b-instruct", []string{"\n\n", "<|im_end|>"}, []string{"\n\n", "<|im_end|>", "<|endoftext|>"}},
+		{"openai/gpt-4o", []string{"END"}, []string{"END"}},
+		{"openai/gpt-4o", nil, nil},
+	}
+	for _, tt := range tests {
+		if got := sequences.Merge(tt.model, tt.stops); !slices.Equal(got, tt.want) {
+			t.Errorf("Merge(%q, %q) = %q, want %q", tt.model, tt.stops, got, tt.want)
+		}
+	}
+}
+
+func TestBuildChatRequestStops(t *testing.T) {
+	previous := stopSequences
+	t.Cleanup(func() { stopSequences = previous })
+	var err error
+	if stopSequences, err = parseStopSequences(`{"qwen/*": ["<|im_end|>"]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	req := buildChatRequest("qwen/qwen-2.5-72b-instruct", nil, Options{Stop: []string{"User:"}}, UpstreamAPIChat)
+	if want := []string{"User:", "<|im_end|>"}; !slices.Equal(req.Stop, want) {
+		t.Errorf("stop = %q, want %q", req.Stop, want)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// streamChunk is a streamed chat completion chunk, extended by the error object
+// some gateways (e.g. OpenRouter) embed in a regular chunk when the request
+// fails mid-stream. Errors sent as the sole content of a frame are already
+// surfaced by go-openai.
+type streamChunk struct {
+	openai.ChatCompletionStreamResponse
+	Error *struct {
+		Code    any    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// StreamResponse is a streamed chat completion chunk plus the reasoning delta
+// of its first choice, which go-openai doesn't decode.
+type StreamResponse struct {
+	openai.ChatCompletionStreamResponse
+	Reasoning string
+}
+
+// recvChunk reads the next chunk from the stream, returning in-band errors as
+// an *openai.APIError. Completion chunks are mapped onto chat deltas.
+func recvChunk(stream *ChatCompletionStream) (StreamResponse, error) {
+	raw, err := stream.RecvRaw()
+	if err != nil {
+		return StreamResponse{}, err
+	}
+
+	var chunk streamChunk
+	if err := json.Unmarshal(raw, &chunk); err != nil {
+		return StreamResponse{}, err
+	}
+
+	if stream.completions {
+		var completion openai.CompletionResponse
+		if err := json.Unmarshal(raw, &completion); err != nil {
+			return StreamResponse{}, err
+		}
+		for i, choice := range completion.Choices {
+			if i < len(chunk.Choices) {
+				chunk.Choices[i].Delta = openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant, Content: choice.Text}
+			}
+		}
+	}
+
+	response := StreamResponse{ChatCompletionStreamResponse: chunk.ChatCompletionStreamResponse, Reasoning: deltaReasoning(raw)}
+	if chunk.Error != nil {
+		apiErr := &openai.APIError{Code: chunk.Error.Code, Message: chunk.Error.Message}
+		if code, ok := chunk.Error.Code.(float64); ok {
+			apiErr.HTTPStatusCode = int(code)
+			apiErr.HTTPStatus = http.StatusText(int(code))
+		}
+		return response, apiErr
+	}
+	for _, choice := range chunk.Choices {
+		if choice.FinishReason == "error" {
+			return response, errors.New("upstream stream finished with an error")
+		}
+	}
+	return response, nil
+}
+
+// firstChoice returns the first choice of a chunk, if any. Ollama responses
+// have a single message, so deltas of further choices (with n > 1) are
+// ignored rather than mixed into it.
+func firstChoice(choices []openai.ChatCompletionStreamChoice) (openai.ChatCompletionStreamChoice, bool) {
+	for _, choice := range choices {
+		if choice.Index == 0 {
+			return choice, true
+		}
+	}
+	return openai.ChatCompletionStreamChoice{}, false
+}
+
+// streamErrorTrailer is the HTTP trailer carrying the error of a stream that
+// failed after its headers were sent.
+const streamErrorTrailer = "X-Stream-Error"
+
+// streamErrorFrame is the final frame of a failed stream. Unlike a regular
+// final frame, it has done_reason "error" and an error message, so clients
+// can tell it from a normal end.
+func streamErrorFrame(model string, createdAt string, role string, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"model":      model,
+		"created_at": createdAt,
+		"message": map[string]string{
+			"role":    role,
+			"content": "",
+		},
+		"done":        true,
+		"done_reason": "error",
+		"error":       message,
+	}
+}
+
+// ndjsonWriter writes newline-delimited frames to a streaming response and
+// flushes each one. With a write timeout set, a client that stops reading
+// makes writes fail instead of blocking the handler indefinitely.
+//
+// To save writes on slow links, flushes can be coalesced: frames are then
+// flushed once flushFrames have accumulated or flushInterval has passed since
+// the first unflushed one, and on Close.
+type ndjsonWriter struct {
+	w             http.ResponseWriter
+	rc            *http.ResponseController
+	writeTimeout  time.Duration
+	flushFrames   int
+	flushInterval time.Duration
+
+	mu         sync.Mutex
+	unflushed  int
+	flushTimer *time.Timer
+	lastWrite  time.Time
+}
+
+func newNDJSONWriter(w http.ResponseWriter, writeTimeout time.Duration, flushFrames int, flushInterval time.Duration) *ndjsonWriter {
+	return &ndjsonWriter{
+		w:             w,
+		rc:            http.NewResponseController(w),
+		writeTimeout:  writeTimeout,
+		flushFrames:   flushFrames,
+		flushInterval: flushInterval,
+	}
+}
+
+func (s *ndjsonWriter) setDeadline() error {
+	if s.writeTimeout > 0 {
+		err := s.rc.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ndjsonWriter) WriteLine(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.setDeadline(); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.lastWrite = time.Now()
+	s.unflushed++
+	if s.unflushed < s.flushFrames {
+		if s.flushInterval > 0 && s.flushTimer == nil {
+			s.flushTimer = time.AfterFunc(s.flushInterval, func() {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				s.flushTimer = nil
+				if s.unflushed > 0 && s.setDeadline() == nil {
+					s.flush()
+				}
+			})
+		}
+		return nil
+	}
+	return s.flush()
+}
+
+// flush must be called with mu held.
+func (s *ndjsonWriter) flush() error {
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+		s.flushTimer = nil
+	}
+	s.unflushed = 0
+	return s.rc.Flush()
+}
+
+func (s *ndjsonWriter) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.WriteLine(data)
+}
+
+// KeepAlive writes line, e.g. an SSE comment, whenever nothing was written
+// for interval, so that proxies and load balancers don't close the connection
+// during long gaps between frames. The returned stop ends it and must be
+// called before Close.
+func (s *ndjsonWriter) KeepAlive(interval time.Duration, line []byte) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	s.mu.Lock()
+	if s.lastWrite.IsZero() {
+		s.lastWrite = time.Now()
+	}
+	s.mu.Unlock()
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			s.mu.Lock()
+			if time.Since(s.lastWrite) >= interval && s.setDeadline() == nil {
+				if _, err := s.w.Write(append(line, '\n')); err == nil {
+					s.lastWrite = time.Now()
+					s.flush()
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Close flushes coalesced frames, e.g. the final one, and clears the write
+// deadline, so it doesn't leak into later responses on the same connection.
+func (s *ndjsonWriter) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unflushed > 0 && s.setDeadline() == nil {
+		s.flush()
+	}
+	if s.flushTimer != nil {
+		s.flushTimer.Stop()
+		s.flushTimer = nil
+	}
+	if s.writeTimeout > 0 {
+		s.rc.SetWriteDeadline(time.Time{})
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// parseSSE returns the data of the events of an SSE stream, skipping
+// comments like an SSE client.
+func parseSSE(t *testing.T, body string) []string {
+	t.Helper()
+	var events []string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, ":") {
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			t.Fatalf("unexpected SSE line %q", line)
+		}
+		events = append(events, data)
+	}
+	return events
+}
+
+func TestKeepAliveDuringGap(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	w := newNDJSONWriter(recorder, 0, 1, 0)
+	stop := w.KeepAlive(20*time.Millisecond, []byte(": keep-alive\n"))
+
+	if err := w.WriteLine([]byte("data: first\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := w.WriteLine([]byte("data: second\n")); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	w.Close()
+
+	body := recorder.Body.String()
+	if n := strings.Count(body, ": keep-alive\n\n"); n < 2 {
+		t.Errorf("got %d keep-alive comments during the gap, want at least 2:\n%s", n, body)
+	}
+	events := parseSSE(t, body)
+	if len(events) != 2 || events[0] != "first" || events[1] != "second" {
+		t.Errorf("events = %q, want [first second]", events)
+	}
+}
+
+// Frames written more often than the interval need no keep-alive.
+func TestKeepAliveNotDuringFrames(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	w := newNDJSONWriter(recorder, 0, 1, 0)
+	stop := w.KeepAlive(50*time.Millisecond, []byte(": keep-alive\n"))
+	for range 10 {
+		if err := w.WriteLine([]byte("data: x\n")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stop()
+	w.Close()
+
+	if strings.Contains(recorder.Body.String(), "keep-alive") {
+		t.Errorf("keep-alive sent between frames:\n%s", recorder.Body.String())
+	}
+}
+
+// A client that stops reading is given up on after CLIENT_WRITE_TIMEOUT,
+// ending the handler and the upstream stream.
+func TestChatStreamSlowClient(t *testing.T) {
+	upstreamDone := make(chan struct{})
+	delta := testChunk(`[{"index":0,"delta":{"content":"`+strings.Repeat("x", 16<<10)+`"}}]`, "")
+	router := newTestRouter(t, routerConfig{clientWriteTimeout: 200 * time.Millisecond, streamFlushFrames: 1}, func(w http.ResponseWriter, r *http.Request) {
+		defer close(upstreamDone)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for r.Context().Err() == nil {
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", delta); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	})
+	handlerDone := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(handlerDone)
+		router.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	// a client that sends the request and never reads the response
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`
+	fmt.Fprintf(conn, "POST /api/chat HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+
+	for name, done := range map[string]chan struct{}{"handler": handlerDone, "upstream stream": upstreamDone} {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s still running after the client stopped reading", name)
+		}
+	}
+}
+
+// Errors some gateways embed in a chunk end the stream with an error frame.
+func TestChatStreamInBandError(t *testing.T) {
+	tests := map[string]string{
+		"error object":             testChunk(`[{"index":0,"delta":{"content":""},"finish_reason":"error"}]`, `"error":{"code":502,"message":"Provider disconnected"}`),
+		"error finish reason":      testChunk(`[{"index":0,"delta":{"content":""},"finish_reason":"error"}]`, ""),
+		"error object, no choices": testChunk(`[]`, `"error":{"code":"server_error","message":"Provider disconnected"}`),
+	}
+	for name, errorChunk := range tests {
+		t.Run(name, func(t *testing.T) {
+			router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+				writeTestStream(w, testChunk(`[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]`, ""), errorChunk)
+			})
+
+			recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`)
+			frames := parseNDJSON(t, recorder.Body.String())
+			if len(frames) != 2 {
+				t.Fatalf("got %d frames, want the delta and an error frame:\n%s", len(frames), recorder.Body)
+			}
+			final := frames[1]
+			if final["done"] != true || final["done_reason"] != "error" || final["error"] == "" || final["error"] == nil {
+				t.Errorf("final frame = %v, want an error frame", final)
+			}
+			if name != "error finish reason" && final["error"] != "Provider disconnected" {
+				t.Errorf("error = %v, want the upstream message", final["error"])
+			}
+			if trailer := recorder.Header().Get(streamErrorTrailer); trailer != final["error"] {
+				t.Errorf("trailer = %q, want %q", trailer, final["error"])
+			}
+		})
+	}
+}
+
+// An error in the first chunk, before anything was streamed, is answered
+// with the upstream status rather than a 200 and an error frame.
+func TestChatStreamInBandErrorFirstChunk(t *testing.T) {
+	router := newTestRouter(t, routerConfig{streamSuffix: "[END]"}, func(w http.ResponseWriter, r *http.Request) {
+		writeTestStream(w, testChunk(`[]`, `"error":{"code":429,"message":"Rate limit exceeded"}`))
+	})
+
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","messages":[{"role":"user","content":"Hi"}]}`)
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429, body %s", recorder.Code, recorder.Body)
+	}
+	var response map[string]any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("body is not a single JSON error: %v\n%s", err, recorder.Body)
+	}
+	if response["error"] != "Rate limit exceeded" {
+		t.Errorf("error = %v, want the upstream message", response["error"])
+	}
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("Content-Type = %q, want JSON", contentType)
+	}
+}
+
+// With n > 1, /api/chat returns the first choice only, never mixing in the
+// others.
+func TestChatMultipleChoices(t *testing.T) {
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Stream bool `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Stream {
+			writeTestStream(w,
+				testChunk(`[{"index":1,"delta":{"role":"assistant","content":"Second"}}]`, ""),
+				testChunk(`[{"index":0,"delta":{"role":"assistant","content":"First"}}]`, ""),
+				testChunk(`[{"index":0,"delta":{"content":" one"},"finish_reason":"stop"},{"index":1,"delta":{"content":" one"},"finish_reason":"length"}]`, ""),
+			)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id": "chatcmpl-test", "object": "chat.completion", "created": 1, "model": "openai/gpt-4o",
+			"choices": []map[string]any{
+				{"index": 0, "message": map[string]any{"role": "assistant", "content": "First one"}, "finish_reason": "stop"},
+				{"index": 1, "message": map[string]any{"role": "assistant", "content": "Second one"}, "finish_reason": "length"},
+			},
+		})
+	})
+
+	for _, stream := range []string{"false", "true"} {
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":`+stream+`,"messages":[{"role":"user","content":"Hi"}]}`)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("stream %s: status = %d, body %s", stream, recorder.Code, recorder.Body)
+		}
+		var content strings.Builder
+		frames := parseNDJSON(t, recorder.Body.String())
+		for _, frame := range frames {
+			content.WriteString(frame["message"].(map[string]any)["content"].(string))
+		}
+		final := frames[len(frames)-1]
+		if content.String() != "First one" || final["done_reason"] != "stop" {
+			t.Errorf("stream %s: content = %q, done_reason %v, want the first choice only", stream, content.String(), final["done_reason"])
+		}
+	}
+}
+
+// /v1/chat/completions forwards n and returns every choice.
+func TestOpenAIChatMultipleChoices(t *testing.T) {
+	var n any
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]any
+		json.NewDecoder(r.Body).Decode(&request)
+		n = request["n"]
+		if request["stream"] == true {
+			writeTestStream(w,
+				testChunk(`[{"index":1,"delta":{"role":"assistant","content":"Second"}}]`, ""),
+				testChunk(`[{"index":0,"delta":{"role":"assistant","content":"First"}}]`, ""),
+				testChunk(`[{"index":0,"delta":{"content":" one"},"finish_reason":"stop"},{"index":1,"delta":{"content":" one"},"finish_reason":"length"}]`, ""),
+			)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id": "chatcmpl-test", "object": "chat.completion", "created": 1, "model": "openai/gpt-4o",
+			"choices": []map[string]any{
+				{"index": 0, "message": map[string]any{"role": "assistant", "content": "First one"}, "finish_reason": "stop"},
+				{"index": 1, "message": map[string]any{"role": "assistant", "content": "Second one"}, "finish_reason": "length"},
+			},
+		})
+	})
+	body := func(stream string) string {
+		return `{"model":"gpt-4o","n":2,"stream":` + stream + `,"messages":[{"role":"user","content":"Hi"}]}`
+	}
+
+	recorder := serveTestRequest(router, http.MethodPost, "/v1/chat/completions", body("false"))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	if n != float64(2) {
+		t.Errorf("upstream n = %v, want 2", n)
+	}
+	var response openai.ChatCompletionResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Choices) != 2 || response.Choices[0].Message.Content != "First one" || response.Choices[1].Message.Content != "Second one" || response.Choices[1].FinishReason != openai.FinishReasonLength {
+		t.Errorf("choices = %+v, want both", response.Choices)
+	}
+
+	n = nil
+	recorder = serveTestRequest(router, http.MethodPost, "/v1/chat/completions", body("true"))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("stream: status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	if n != float64(2) {
+		t.Errorf("stream: upstream n = %v, want 2", n)
+	}
+	events := parseSSE(t, recorder.Body.String())
+	if len(events) == 0 || events[len(events)-1] != "[DONE]" {
+		t.Fatalf("events = %q, want them to end with [DONE]", events)
+	}
+	contents := make(map[int]string)
+	finishReasons := make(map[int]openai.FinishReason)
+	for _, event := range events[:len(events)-1] {
+		var chunk openai.ChatCompletionStreamResponse
+		if err := json.Unmarshal([]byte(event), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		for _, choice := range chunk.Choices {
+			contents[choice.Index] += choice.Delta.Content
+			if choice.FinishReason != "" {
+				finishReasons[choice.Index] = choice.FinishReason
+			}
+		}
+	}
+	if contents[0] != "First one" || contents[1] != "Second one" {
+		t.Errorf("stream: contents = %q, want both choices", contents)
+	}
+	if finishReasons[0] != openai.FinishReasonStop || finishReasons[1] != openai.FinishReasonLength {
+		t.Errorf("stream: finish reasons = %v, want stop and length", finishReasons)
+	}
+}
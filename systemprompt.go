@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// SystemPromptMode controls how SYSTEM_PROMPT is combined with a system
+// message sent by the client.
+type SystemPromptMode string
+
+const (
+	// SystemPromptPrepend adds the system prompt as a message of its own
+	// ahead of all others.
+	SystemPromptPrepend SystemPromptMode = "prepend"
+	// SystemPromptMerge puts the system prompt at the start of the client's
+	// first system message, or prepends it if there is none.
+	SystemPromptMerge SystemPromptMode = "merge"
+)
+
+var (
+	systemPrompt     string
+	systemPromptMode = SystemPromptPrepend
+)
+
+func parseSystemPromptMode(value string) (SystemPromptMode, error) {
+	switch mode := SystemPromptMode(value); mode {
+	case "":
+		return SystemPromptPrepend, nil
+	case SystemPromptPrepend, SystemPromptMerge:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown system prompt mode %q", value)
+	}
+}
+
+// withSystemPrompt adds the configured system prompt to the messages. Requests
+// without messages, e.g. to unload a model, are left as they are.
+func withSystemPrompt(messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	if systemPrompt == "" || len(messages) == 0 {
+		return messages
+	}
+
+	if systemPromptMode == SystemPromptMerge {
+		for i, message := range messages {
+			if message.Role != openai.ChatMessageRoleSystem {
+				continue
+			}
+			merged := append([]openai.ChatCompletionMessage(nil), messages...)
+			if len(message.MultiContent) > 0 {
+				part := openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: systemPrompt + "\n\n"}
+				merged[i].MultiContent = append([]openai.ChatMessagePart{part}, message.MultiContent...)
+			} else {
+				merged[i].Content = systemPrompt + "\n\n" + message.Content
+			}
+			return merged
+		}
+	}
+
+	system := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: systemPrompt}
+	return append([]openai.ChatCompletionMessage{system}, messages...)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func init() {
+	// the encodings are embedded rather than downloaded on first use
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// openAIEncodings are the tokenizers of OpenAI model families, matched in
+// order like maxCompletionTokensModels, so that their prompts are counted
+// exactly rather than estimated.
+var openAIEncodings = []struct {
+	family   string
+	encoding string
+}{
+	{"gpt-4o", tiktoken.MODEL_O200K_BASE},
+	{"gpt-4.1", tiktoken.MODEL_O200K_BASE},
+	{"gpt-4.5", tiktoken.MODEL_O200K_BASE},
+	{"gpt-5", tiktoken.MODEL_O200K_BASE},
+	{"o1", tiktoken.MODEL_O200K_BASE},
+	{"o3", tiktoken.MODEL_O200K_BASE},
+	{"o4", tiktoken.MODEL_O200K_BASE},
+	{"gpt-4", tiktoken.MODEL_CL100K_BASE},
+	{"gpt-3.5", tiktoken.MODEL_CL100K_BASE},
+}
+
+// tokenizers load each encoding once, on first use, as building one takes a
+// while.
+var tokenizers = map[string]func() *tiktoken.Tiktoken{
+	tiktoken.MODEL_O200K_BASE:  loadTokenizer(tiktoken.MODEL_O200K_BASE),
+	tiktoken.MODEL_CL100K_BASE: loadTokenizer(tiktoken.MODEL_CL100K_BASE),
+}
+
+// loadTokenizer returns a function loading the encoding on its first call,
+// which returns nil from then on if that failed.
+func loadTokenizer(encoding string) func() *tiktoken.Tiktoken {
+	return sync.OnceValue(func() *tiktoken.Tiktoken {
+		tokenizer, err := tiktoken.GetEncoding(encoding)
+		if err != nil {
+			slog.Warn("Error loading tokenizer, estimating tokens instead", "encoding", encoding, "Error", err)
+			return nil
+		}
+		return tokenizer
+	})
+}
+
+// charsPerToken approximates how many characters of English text one token
+// covers in the tokenizers of common model families. Families are matched
+// against the model name in order; others use defaultCharsPerToken.
+var charsPerToken = []struct {
+	family string
+	chars  float64
+}{
+	{"claude", 3.5},
+	{"gemini", 4},
+	{"gpt", 4},
+	{"llama", 3.8},
+	{"mistral", 3.5},
+	{"mixtral", 3.5},
+	{"qwen", 3.7},
+	{"deepseek", 3.7},
+}
+
+const (
+	defaultCharsPerToken = 4
+	// tokensPerMessage is the overhead of a message's role and delimiters,
+	// tokensPerPrompt that of priming the reply.
+	tokensPerMessage = 4
+	tokensPerPrompt  = 3
+	// tokensPerImage is what OpenAI charges for a low-detail image.
+	tokensPerImage = 85
+)
+
+// estimatePromptTokens estimates the prompt tokens of the messages for a
+// model. The text of OpenAI models is tokenized, that of others estimated
+// from its length: characters outside ASCII, e.g. CJK text, are counted as a
+// token each, as tokenizers rarely merge them.
+func estimatePromptTokens(messages []openai.ChatCompletionMessage, model string) int {
+	count := textTokenCounter(model)
+	tokens := tokensPerPrompt
+	for _, message := range messages {
+		tokens += tokensPerMessage + count(message.Content)
+		for _, part := range message.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeImageURL {
+				tokens += tokensPerImage
+			}
+			tokens += count(part.Text)
+		}
+	}
+	return tokens
+}
+
+// estimateCompletionTokens estimates the tokens of generated text, for
+// backends that report no usage.
+func estimateCompletionTokens(text string, model string) int {
+	return textTokenCounter(model)(text)
+}
+
+// textTokenCounter returns a function counting the tokens of text for a
+// model, with its tokenizer if known and estimated otherwise.
+func textTokenCounter(model string) func(string) int {
+	if tokenizer := tokenizerFor(model); tokenizer != nil {
+		return func(text string) int { return len(tokenizer.EncodeOrdinary(text)) }
+	}
+	ratio := charsPerTokenFor(model)
+	return func(text string) int { return textTokens(text, ratio) }
+}
+
+// tokenizerFor returns the tokenizer of an OpenAI model, or nil for other
+// models or if it failed to load.
+func tokenizerFor(model string) *tiktoken.Tiktoken {
+	vendor, name, ok := strings.Cut(strings.ToLower(model), "/")
+	if !ok {
+		name = vendor
+	} else if vendor != "openai" {
+		return nil
+	}
+	for _, family := range openAIEncodings {
+		if name == family.family || strings.HasPrefix(name, family.family+"-") || strings.HasPrefix(name, family.family+":") {
+			return tokenizers[family.encoding]()
+		}
+	}
+	return nil
+}
+
+func charsPerTokenFor(model string) float64 {
+	lower := strings.ToLower(model)
+	for _, family := range charsPerToken {
+		if strings.Contains(lower, family.family) {
+			return family.chars
+		}
+	}
+	return defaultCharsPerToken
+}
+
+func textTokens(text string, charsPerToken float64) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return int(math.Ceil(float64(ascii)/charsPerToken)) + other
+}
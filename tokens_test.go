@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// OpenAI models are counted with their tokenizer, others estimated.
+func TestTextTokenCounter(t *testing.T) {
+	text := "The quick brown fox jumps over the lazy dog."
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"gpt-4o", 10},
+		{"openai/gpt-4o-mini", 10},
+		{"openai/o3-mini", 10},
+		{"openai/gpt-4-turbo", 10},
+		{"anthropic/claude-3.5-sonnet", 13}, // 44 characters at 3.5 a token
+		{"meta/gpt-4o", 11},                 // not OpenAI's, at 4 characters a token
+	}
+	for _, tt := range tests {
+		if got := textTokenCounter(tt.model)(text); got != tt.want {
+			t.Errorf("%s: %d tokens, want %d", tt.model, got, tt.want)
+		}
+	}
+
+	// special tokens in the text are counted as text instead of failing
+	if got := estimateCompletionTokens("<|endoftext|>", "gpt-4o"); got <= 1 {
+		t.Errorf("special token counted as %d tokens, want it tokenized as text", got)
+	}
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: text}}
+	if got, want := estimatePromptTokens(messages, "gpt-4o"), tokensPerPrompt+tokensPerMessage+10; got != want {
+		t.Errorf("prompt tokens = %d, want %d", got, want)
+	}
+}
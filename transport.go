@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// newHTTPTransport returns the transport that connects to the upstream, with
+// its connection pool sized by UPSTREAM_MAX_IDLE_CONNS,
+// UPSTREAM_MAX_IDLE_CONNS_PER_HOST and UPSTREAM_IDLE_CONN_TIMEOUT. Go's
+// default of two idle connections per host makes clients streaming in parallel
+// from a single upstream open new connections all the time. HTTP/2 is
+// negotiated over TLS unless UPSTREAM_HTTP2 is false. Like Go's default
+// transport, it honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY, unless
+// UPSTREAM_PROXY overrides them. TLS is configured by upstreamTLSConfig.
+func newHTTPTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy, err := upstreamProxy(); err == nil && proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if tlsConfig, err := upstreamTLSConfig(); err == nil && tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	transport.MaxIdleConns = getEnvInt("UPSTREAM_MAX_IDLE_CONNS", 100)
+	transport.MaxIdleConnsPerHost = getEnvInt("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 32)
+	transport.IdleConnTimeout = getEnvDuration("UPSTREAM_IDLE_CONN_TIMEOUT", 90*time.Second)
+	transport.ForceAttemptHTTP2 = getEnvBool("UPSTREAM_HTTP2", true)
+	if !transport.ForceAttemptHTTP2 {
+		// a non-nil, empty map disables HTTP/2
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return transport
+}
+
+// upstreamProxy parses UPSTREAM_PROXY, the proxy all upstream requests are
+// sent through, including those to hosts excluded by NO_PROXY. It is nil when
+// unset.
+func upstreamProxy() (*url.URL, error) {
+	value := os.Getenv("UPSTREAM_PROXY")
+	if value == "" {
+		return nil, nil
+	}
+	proxy, err := url.Parse(value)
+	if err != nil || proxy.Host == "" {
+		return nil, fmt.Errorf("UPSTREAM_PROXY %q is not a proxy URL, e.g. http://proxy.example.com:3128", value)
+	}
+	return proxy, nil
+}
+
+// upstreamTLSConfig builds the TLS config for self-hosted upstreams: the
+// certificates in UPSTREAM_CA_FILE are trusted in addition to the system
+// ones, and INSECURE_SKIP_VERIFY turns off certificate verification entirely.
+// It is nil when neither is set.
+func upstreamTLSConfig() (*tls.Config, error) {
+	caFile := os.Getenv("UPSTREAM_CA_FILE")
+	insecure := getEnvBool("INSECURE_SKIP_VERIFY", false)
+	if caFile == "" && !insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading UPSTREAM_CA_FILE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("UPSTREAM_CA_FILE %s contains no PEM certificates", caFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+type extraBodyKey struct{}
+
+// withExtraBody attaches fields to be merged into the JSON body of upstream
+// requests made with the returned context.
+func withExtraBody(ctx context.Context, fields map[string]any) context.Context {
+	if len(fields) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, extraBodyKey{}, fields)
+}
+
+type rawBodyKey struct{}
+
+// withRawBody makes the JSON body of a successful upstream response to a
+// request made with the returned context available in *body, for fields
+// go-openai doesn't decode.
+func withRawBody(ctx context.Context, body *[]byte) context.Context {
+	return context.WithValue(ctx, rawBodyKey{}, body)
+}
+
+type upstreamHeadersKey struct{}
+
+// withUpstreamHeaders makes upstream requests made with the returned context
+// pass the headers of their response to fn, including error responses.
+func withUpstreamHeaders(ctx context.Context, fn func(http.Header)) context.Context {
+	return context.WithValue(ctx, upstreamHeadersKey{}, fn)
+}
+
+// upstreamTransport decorates requests to the upstream API with static headers
+// (e.g. OpenRouter attribution) and the request ID of the client request they
+// are made for, and normalizes error responses.
+type upstreamTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+// newUpstreamTransport reads the optional OpenRouter attribution headers from
+// OPENROUTER_REFERER and OPENROUTER_TITLE.
+func newUpstreamTransport(base http.RoundTripper) *upstreamTransport {
+	headers := make(http.Header)
+	if referer := os.Getenv("OPENROUTER_REFERER"); referer != "" {
+		headers.Set("HTTP-Referer", referer)
+	}
+	if title := os.Getenv("OPENROUTER_TITLE"); title != "" {
+		headers.Set("X-Title", title)
+	}
+	return &upstreamTransport{base: base, headers: headers}
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestID := requestIDFromContext(req.Context())
+	extra, _ := req.Context().Value(extraBodyKey{}).(map[string]any)
+	if len(t.headers) == 0 && requestID == "" && extra == nil {
+		return t.roundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for key, values := range t.headers {
+		req.Header[key] = values
+	}
+	if requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+	if extra != nil && req.Body != nil {
+		if err := mergeBody(req, extra); err != nil {
+			return nil, err
+		}
+	}
+	return t.roundTrip(req)
+}
+
+func (t *upstreamTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if fn, ok := req.Context().Value(upstreamHeadersKey{}).(func(http.Header)); ok {
+		fn(resp.Header)
+	}
+	return inspectJSONBody(resp)
+}
+
+// inspectJSONBody turns a 200 response carrying an error object instead of a
+// result, as returned by some gateways, into an error response so that
+// go-openai reports it as an *openai.APIError. The status is taken from the
+// error's code if it is an HTTP status, or else 502. Successful bodies are
+// handed to withRawBody.
+func inspectJSONBody(resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		return resp, nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	var body struct {
+		Error *struct {
+			Code any `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &body) != nil || body.Error == nil {
+		if raw, ok := resp.Request.Context().Value(rawBodyKey{}).(*[]byte); ok {
+			*raw = data
+		}
+		return resp, nil
+	}
+
+	status := http.StatusBadGateway
+	if code, ok := body.Error.Code.(float64); ok && code >= 400 && code < 600 {
+		status = int(code)
+	}
+	resp.StatusCode = status
+	resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+	return resp, nil
+}
+
+// mergeBody sets the given fields on the request's JSON object body.
+func mergeBody(req *http.Request, fields map[string]any) error {
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+	for key, value := range fields {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		body[key] = raw
+	}
+
+	if data, err = json.Marshal(body); err != nil {
+		return err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.ContentLength = int64(len(data))
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Gateways answering 200 with an error object fail the request like an error
+// status would.
+func TestChat200WithErrorBody(t *testing.T) {
+	t.Setenv("UPSTREAM_MAX_ATTEMPTS", "1")
+	tests := []struct {
+		code       string
+		wantStatus int
+	}{
+		{`429`, http.StatusTooManyRequests},
+		{`"server_error"`, http.StatusBadGateway},
+		{`503`, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"error":{"code":%s,"message":"Provider returned error"}}`, tt.code)
+		})
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"Hi"}]}`)
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("code %s: status = %d, want %d", tt.code, recorder.Code, tt.wantStatus)
+			continue
+		}
+		var body map[string]any
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if body["error"] != "Provider returned error" {
+			t.Errorf("code %s: body = %v, want the upstream message", tt.code, body)
+		}
+	}
+}
+
+// Successful responses pass through the inspection unchanged.
+func TestChat200WithoutErrorBody(t *testing.T) {
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		writeTestCompletion(w, "Hi!")
+	})
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"Hi"}]}`)
+	var body struct {
+		Message map[string]string `json:"message"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusOK || body.Message["content"] != "Hi!" {
+		t.Errorf("status = %d, body %s, want the content", recorder.Code, recorder.Body)
+	}
+}
+
+// With UPSTREAM_PROXY set, upstream requests are sent through the proxy.
+func TestUpstreamProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a forward proxy receives the absolute URL of the upstream
+		proxied = append(proxied, r.URL.String())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": testModels})
+	}))
+	t.Cleanup(proxy.Close)
+	t.Setenv("UPSTREAM_PROXY", proxy.URL)
+
+	models, err := NewOpenrouterProvider("http://upstream.invalid/api/v1", "sk-test").GetModels()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(models) != len(testModels) {
+		t.Errorf("got %d models, want %d", len(models), len(testModels))
+	}
+	if len(proxied) != 1 || proxied[0] != "http://upstream.invalid/api/v1/models" {
+		t.Errorf("proxied requests = %q, want the upstream model list", proxied)
+	}
+}
+
+func TestUpstreamProxyInvalid(t *testing.T) {
+	for _, value := range []string{"proxy.example.com:3128", "://"} {
+		t.Setenv("UPSTREAM_PROXY", value)
+		if _, err := upstreamProxy(); err == nil {
+			t.Errorf("upstreamProxy(%q) succeeded, want an error", value)
+		}
+	}
+}
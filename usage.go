@@ -0,0 +1,26 @@
+package main
+
+import (
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// usageStats maps the token usage of a response and its measured timings onto
+// the statistics of a final Ollama frame, from which clients derive tokens per
+// second. firstToken is when the first delta arrived; for responses that
+// aren't streamed it is zero, and all of their time counts as evaluation.
+func usageStats(usage openai.Usage, start time.Time, firstToken time.Time) map[string]any {
+	end := time.Now()
+	if firstToken.IsZero() {
+		firstToken = start
+	}
+	return map[string]any{
+		"total_duration":       end.Sub(start).Nanoseconds(),
+		"load_duration":        0,
+		"prompt_eval_count":    usage.PromptTokens,
+		"prompt_eval_duration": firstToken.Sub(start).Nanoseconds(),
+		"eval_count":           usage.CompletionTokens,
+		"eval_duration":        end.Sub(firstToken).Nanoseconds(),
+	}
+}
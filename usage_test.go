@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Backends reporting no usage get estimated token counts rather than zeros.
+func TestChatWithoutUsage(t *testing.T) {
+	const prompt, reply = "What is the capital of France?", "The capital of France is Paris."
+	router := newTestRouter(t, routerConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		var request openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Stream {
+			writeTestStream(w, testChunk(`[{"index":0,"delta":{"role":"assistant","content":"`+reply+`"},"finish_reason":"stop"}]`, ""))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id": "chatcmpl-test", "object": "chat.completion", "created": 1, "model": "openai/gpt-4o",
+			"choices": []map[string]any{{"index": 0, "message": map[string]any{"role": "assistant", "content": reply}, "finish_reason": "stop"}},
+		})
+	})
+
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prompt}}
+	wantPrompt := float64(estimatePromptTokens(messages, "gpt-4o"))
+	wantCompletion := float64(estimateCompletionTokens(reply, "openai/gpt-4o"))
+	if wantPrompt == 0 || wantCompletion == 0 {
+		t.Fatalf("estimates = %v, %v, want non-zero counts", wantPrompt, wantCompletion)
+	}
+	for _, stream := range []string{"false", "true"} {
+		recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":`+stream+`,"messages":[{"role":"user","content":"`+prompt+`"}]}`)
+		frames := parseNDJSON(t, recorder.Body.String())
+		final := frames[len(frames)-1]
+		if final["prompt_eval_count"] != wantPrompt || final["eval_count"] != wantCompletion {
+			t.Errorf("stream %s: counts = %v, %v, want the estimates %v, %v", stream, final["prompt_eval_count"], final["eval_count"], wantPrompt, wantCompletion)
+		}
+		if duration, _ := final["total_duration"].(float64); duration <= 0 {
+			t.Errorf("stream %s: total_duration = %v, want the elapsed time", stream, final["total_duration"])
+		}
+	}
+}
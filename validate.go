@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"unicode/utf8"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// promptChars counts the characters of all text content in the messages.
+func promptChars(messages []openai.ChatCompletionMessage) int {
+	n := 0
+	for _, message := range messages {
+		n += utf8.RuneCountInString(message.Content)
+		for _, part := range message.MultiContent {
+			n += utf8.RuneCountInString(part.Text)
+		}
+	}
+	return n
+}
+
+func hasImages(messages []openai.ChatCompletionMessage) bool {
+	for _, message := range messages {
+		for _, part := range message.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeImageURL {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkImageSupport rejects images sent to a model known not to accept them,
+// which the backend would otherwise fail with an opaque error.
+func checkImageSupport(provider *OpenrouterProvider, fullModelName string, messages []openai.ChatCompletionMessage) error {
+	if !hasImages(messages) || provider.modelSupportsVision(fullModelName) {
+		return nil
+	}
+	message := fmt.Sprintf("model %s does not support images", fullModelName)
+	if alternative := provider.visionAlternative(fullModelName); alternative != "" {
+		message += ", try a vision model such as " + alternative
+	}
+	return errors.New(message)
+}
+
+// checkNumCtx warns when a client asks for a larger context window than the
+// model has, since num_ctx can't be applied to the backend.
+func checkNumCtx(ctx context.Context, provider *OpenrouterProvider, fullModelName string, numCtx int) {
+	if numCtx <= 0 {
+		return
+	}
+	info, ok := provider.modelInfoFor(fullModelName)
+	if ok && info.ContextLength > 0 && numCtx > info.ContextLength {
+		slog.WarnContext(ctx, "Requested num_ctx exceeds the model's context length", "model", fullModelName, "numCtx", numCtx, "contextLength", info.ContextLength)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+func TestPromptChars(t *testing.T) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "Be brief."},
+		{Role: openai.ChatMessageRoleUser, MultiContent: []openai.ChatMessagePart{
+			{Type: openai.ChatMessagePartTypeText, Text: "Grüße"},
+			{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: "https://example.com/cat.png"}},
+		}},
+	}
+	if n := promptChars(messages); n != 14 {
+		t.Errorf("promptChars = %d, want 14", n)
+	}
+}
+
+// Prompts over MAX_PROMPT_CHARS are rejected without calling the backend.
+func TestChatMaxPromptChars(t *testing.T) {
+	var calls atomic.Int32
+	router := newTestRouter(t, routerConfig{maxPromptChars: 10}, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeTestCompletion(w, "Hi!")
+	})
+
+	recorder := serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"Grüße, wie geht's?"}]}`)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "18 characters exceeds the limit of 10") {
+		t.Errorf("status = %d, body %s, want a 400 for the long prompt", recorder.Code, recorder.Body)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("backend called %d times for a rejected prompt", n)
+	}
+
+	recorder = serveTestRequest(router, http.MethodPost, "/api/chat", `{"model":"gpt-4o","stream":false,"messages":[{"role":"user","content":"Grüße"}]}`)
+	if recorder.Code != http.StatusOK || calls.Load() != 1 {
+		t.Errorf("status = %d, body %s, want a short prompt to pass", recorder.Code, recorder.Body)
+	}
+}